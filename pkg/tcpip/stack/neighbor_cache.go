@@ -0,0 +1,645 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// LinkAddressResolver is implemented by network protocols that need to
+// resolve link addresses before packets can be sent to a neighbor, e.g. ARP
+// for IPv4 and NDP for IPv6.
+type LinkAddressResolver interface {
+	// LinkAddressRequest sends a request for the LinkAddress of addr.
+	// Broadcasts to the local network if linkAddr is the zero value.
+	LinkAddressRequest(addr, localAddr tcpip.Address, linkAddr tcpip.LinkAddress) tcpip.Error
+
+	// ResolveStaticAddress attempts to resolve address without sending
+	// requests. It either resolves the name immediately or returns the
+	// empty LinkAddress.
+	ResolveStaticAddress(addr tcpip.Address) (tcpip.LinkAddress, bool)
+
+	// LinkAddressProtocol returns the network protocol of the addresses
+	// this resolver can resolve.
+	LinkAddressProtocol() tcpip.NetworkProtocolNumber
+}
+
+// linkResolver bundles a neighborCache with the LinkAddressResolver used to
+// populate it, so that a NIC can keep one per network protocol that requires
+// link address resolution.
+type linkResolver struct {
+	resolver LinkAddressResolver
+	neigh    neighborCache
+}
+
+// neighborCache maps IP addresses to link addresses for a particular NIC and
+// network protocol. It implements the Neighbor Unreachability Detection
+// state machine described by RFC 4861 section 7.3.
+type neighborCache struct {
+	nic     *nic
+	linkRes LinkAddressResolver
+	state   *NUDState
+
+	// stats holds the NUD event counters tracked for every neighborEntry
+	// that has ever belonged to this cache.
+	stats NUDStats
+
+	// timers is the shared timer wheel backing every neighborEntry's
+	// retransmit, delay-first-probe and reachable-timeout jobs.
+	timers neighborTimers
+
+	// watchers holds every NeighborWatcher currently subscribed to this
+	// cache's events, in addition to whatever NUDDispatcher is configured on
+	// the stack.
+	watchers neighborWatcherSet
+
+	// chanSubs holds every channel-based Stack.SubscribeNeighborEvents
+	// subscriber currently registered on this cache.
+	chanSubs neighborEventChannelSet
+
+	mu struct {
+		sync.RWMutex
+
+		cache map[tcpip.Address]*neighborEntry
+
+		// disableDAD is set by Stack.SetNICDADDisabled and consulted by the
+		// IPv6 NDP Duplicate Address Detection implementation before it
+		// probes a tentative address on this NIC. It has no effect within
+		// this package, whose NUD state machine does not itself perform
+		// DAD.
+		disableDAD bool
+
+		// forceStaleOnBringup is set by Stack.SetNICForceStaleOnBringup.
+		// When true, a neighbor entry is created directly in the Stale
+		// state instead of Unknown, skipping the Incomplete probing phase
+		// entirely - useful on links such as a sandbox's point-to-point
+		// veth pair, where the peer is known reachable a priori and
+		// multicast/broadcast probing is either wasted or actively
+		// unwanted.
+		forceStaleOnBringup bool
+	}
+}
+
+// init initializes the neighbor cache for use with the given NIC and link
+// address resolver. It must be called exactly once before the cache is used.
+func (n *neighborCache) init(nic *nic, linkRes LinkAddressResolver) {
+	*n = neighborCache{
+		nic:     nic,
+		linkRes: linkRes,
+		state:   NewNUDState(nic.stack.nudConfigs, nic.stack.randomGenerator),
+	}
+	n.mu.cache = make(map[tcpip.Address]*neighborEntry)
+	n.timers.init(nic.stack.clock)
+	n.watchers.init()
+	n.chanSubs.init()
+}
+
+// notifySubscribersLocked fans an event out to every NeighborWatcher and
+// channel-based Stack.SubscribeNeighborEvents subscriber registered on this
+// cache.
+func (n *neighborCache) notifySubscribersLocked(eventType NeighborEventType, reason NeighborEventReason, entry NeighborEntry) {
+	evt := NeighborEvent{Type: eventType, NICID: n.nic.id, Entry: entry, Reason: reason}
+	n.watchers.notifyAll(evt)
+	n.chanSubs.notifyAll(evt)
+}
+
+// getOrCreateEntryLocked returns the entry for addr, creating it if it does
+// not already exist. A new entry starts in the Unknown state, unless
+// forceStaleOnBringup is set, in which case it starts directly in Stale.
+//
+// Precondition: n.mu MUST be locked for writing.
+func (n *neighborCache) getOrCreateEntryLocked(addr tcpip.Address) *neighborEntry {
+	if entry, ok := n.mu.cache[addr]; ok {
+		return entry
+	}
+	entry := newNeighborEntry(n, addr, n.state)
+	if n.mu.forceStaleOnBringup {
+		entry.mu.Lock()
+		entry.setStateLocked(Stale)
+		entry.dispatchAddEventLocked(n.nic.stack.nudDisp, ReasonForceStale)
+		entry.mu.Unlock()
+	}
+	n.mu.cache[addr] = entry
+	n.stats.EntriesCreated.Increment()
+	n.gcLocked()
+	return entry
+}
+
+// gcLocked evicts the least-recently-used entry in Stale, Unreachable or
+// Failed - the only states eligible, since Incomplete, Delay and Probe are
+// actively resolving and Static is pinned by the control plane - once the
+// cache holds more entries than NUDConfigurations.MaxNeighborEntries allows.
+// It is a no-op if the cache is within its cap, or if every entry over the
+// cap happens to be ineligible for eviction.
+//
+// Precondition: n.mu MUST be locked for writing.
+func (n *neighborCache) gcLocked() {
+	if len(n.mu.cache) <= int(n.state.Config().MaxNeighborEntries) {
+		return
+	}
+
+	var (
+		oldest      *neighborEntry
+		oldestAddr  tcpip.Address
+		oldestNanos int64
+	)
+	for addr, entry := range n.mu.cache {
+		entry.mu.Lock()
+		state := entry.mu.neigh.State
+		nanos := entry.mu.lastUsedNanos
+		entry.mu.Unlock()
+
+		if state != Stale && state != Unreachable && state != Failed {
+			continue
+		}
+		if oldest == nil || nanos < oldestNanos {
+			oldest = entry
+			oldestAddr = addr
+			oldestNanos = nanos
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	oldest.mu.Lock()
+	oldest.cancelJobLocked()
+	oldest.dispatchRemoveEventLocked(n.nic.stack.nudDisp, ReasonGCEvicted)
+	oldest.failPendingPacketsLocked()
+	state := oldest.mu.neigh.State
+	oldest.mu.Unlock()
+	n.removeLocked(oldestAddr, state)
+	n.stats.EntriesEvicted.Increment()
+}
+
+// entry returns a snapshot of the neighbor entry for addr, creating a new
+// Unknown entry and kicking off address resolution for localAddr if one does
+// not already exist. If pkt is non-nil, it is queued for transmission once
+// the entry resolves (subject to NUDConfigurations.MaxPendingPacketsPerNeighbor),
+// and done is called exactly once with the outcome.
+func (n *neighborCache) entry(addr, localAddr tcpip.Address, pkt *PacketBuffer, done func(linkAddr tcpip.LinkAddress, err tcpip.Error)) (NeighborEntry, bool) {
+	n.mu.Lock()
+	entry := n.getOrCreateEntryLocked(addr)
+	entry.mu.Lock()
+	entry.handlePacketQueuedLocked(localAddr)
+	if pkt != nil {
+		entry.queuePacketLocked(pkt, done)
+	}
+	neigh := entry.mu.neigh
+	entry.mu.Unlock()
+	n.mu.Unlock()
+	return neigh, neigh.State == Reachable || neigh.State == Static
+}
+
+// handleGratuitous processes a gratuitous ARP reply or an unsolicited NA
+// with the Override flag set for addr. If an entry already exists, it is
+// updated via handleGratuitousLocked. Otherwise, a new entry is created
+// directly in the Stale state only if NUDConfigurations.LearnFromGratuitous
+// is enabled; with it disabled, the gratuitous update is ignored rather than
+// used to learn a new neighbor, since doing so unconditionally would be a
+// cache-poisoning vector.
+func (n *neighborCache) handleGratuitous(addr tcpip.Address, linkAddr tcpip.LinkAddress, isRouter bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if entry, ok := n.mu.cache[addr]; ok {
+		entry.mu.Lock()
+		entry.handleGratuitousLocked(linkAddr, isRouter)
+		entry.mu.Unlock()
+		return
+	}
+
+	if !n.state.Config().LearnFromGratuitous {
+		return
+	}
+
+	entry := newNeighborEntry(n, addr, n.state)
+	entry.mu.Lock()
+	entry.mu.neigh.LinkAddr = linkAddr
+	entry.mu.isRouter = isRouter
+	entry.setStateLocked(Stale)
+	entry.dispatchAddEventLocked(n.nic.stack.nudDisp, ReasonGratuitous)
+	entry.mu.Unlock()
+	n.mu.cache[addr] = entry
+	n.stats.EntriesCreated.Increment()
+	n.gcLocked()
+}
+
+// HandleUpperLevelConfirmation processes a positive reachability
+// confirmation for addr delivered by an upper-layer protocol, such as a TCP
+// endpoint accepting an in-window ACK that advances SND.UNA, as per RFC 4861
+// section 7.3.1. It is a no-op if no entry exists for addr, since an
+// upper-layer hint has nothing to confirm without an entry already tracking
+// that neighbor's reachability.
+func (n *neighborCache) HandleUpperLevelConfirmation(addr tcpip.Address) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	entry, ok := n.mu.cache[addr]
+	if !ok {
+		return
+	}
+	entry.mu.Lock()
+	entry.handleUpperLevelConfirmationLocked()
+	entry.mu.Unlock()
+}
+
+// removeLocked removes the entry for addr from the cache, if present,
+// decrementing the EntriesByState gauge for its state at the time of
+// removal.
+//
+// Precondition: n.mu MUST be locked for writing.
+func (n *neighborCache) removeLocked(addr tcpip.Address, state NeighborState) {
+	delete(n.mu.cache, addr)
+	n.stats.EntriesByState[state].Decrement()
+}
+
+// removeEntry removes the entry for addr from the cache and cancels any of
+// its outstanding jobs.
+func (n *neighborCache) removeEntry(addr tcpip.Address) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	entry, ok := n.mu.cache[addr]
+	if !ok {
+		return
+	}
+	entry.mu.Lock()
+	entry.cancelJobLocked()
+	entry.dispatchRemoveEventLocked(n.nic.stack.nudDisp, ReasonRemove)
+	entry.failPendingPacketsLocked()
+	state := entry.mu.neigh.State
+	entry.mu.Unlock()
+	n.removeLocked(addr, state)
+}
+
+// removeStaticEntry removes the entry for addr only if it is currently
+// Static, reporting whether it did so. Unlike removeEntry, it leaves a
+// dynamic entry for addr untouched, so a caller that only means to unpin an
+// administratively-added neighbor cannot accidentally tear down one the NUD
+// state machine is still resolving.
+func (n *neighborCache) removeStaticEntry(addr tcpip.Address) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	entry, ok := n.mu.cache[addr]
+	if !ok {
+		return false
+	}
+	entry.mu.Lock()
+	if entry.mu.neigh.State != Static {
+		entry.mu.Unlock()
+		return false
+	}
+	entry.dispatchRemoveEventLocked(n.nic.stack.nudDisp, ReasonRemove)
+	entry.failPendingPacketsLocked()
+	entry.mu.Unlock()
+	n.removeLocked(addr, Static)
+	return true
+}
+
+// addStaticEntry adds or overwrites the entry for addr with a Static entry
+// bound to linkAddr. A Static entry bypasses the NUD state machine entirely:
+// it is immune to probes, confirmations, reachability timers and cache
+// eviction.
+//
+// If addr already has a Static entry, linkAddr overwrites it in place and a
+// single Changed event is dispatched - re-pinning an address does not tear
+// down and recreate the entry. If addr instead has a dynamic entry, any of
+// its outstanding retransmit, delay or reachable timer jobs are canceled and
+// its pending packets are flushed to linkAddr rather than failed, since the
+// address has in fact just been resolved (administratively, rather than via
+// NUD); a Removed event is dispatched for the replaced dynamic entry,
+// followed by an Added event for the new Static one.
+func (n *neighborCache) addStaticEntry(addr tcpip.Address, linkAddr tcpip.LinkAddress) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if old, ok := n.mu.cache[addr]; ok {
+		old.mu.Lock()
+		if old.mu.neigh.State == Static {
+			if old.mu.neigh.LinkAddr != linkAddr {
+				old.mu.neigh.LinkAddr = linkAddr
+				old.dispatchChangeEventLocked(n.nic.stack.nudDisp, ReasonStaticAdd)
+			}
+			old.mu.Unlock()
+			return
+		}
+
+		old.cancelJobLocked()
+		old.dispatchRemoveEventLocked(n.nic.stack.nudDisp, ReasonStaticAdd)
+		pending := old.mu.pending
+		old.mu.pending = nil
+		oldState := old.mu.neigh.State
+		old.mu.Unlock()
+		n.removeLocked(addr, oldState)
+
+		entry := newNeighborEntry(n, addr, n.state)
+		entry.mu.Lock()
+		entry.mu.neigh.LinkAddr = linkAddr
+		entry.setStateLocked(Static)
+		entry.mu.pending = pending
+		entry.dispatchAddEventLocked(n.nic.stack.nudDisp, ReasonStaticAdd)
+		entry.drainPendingPacketsLocked()
+		entry.mu.Unlock()
+		n.mu.cache[addr] = entry
+		n.stats.EntriesCreated.Increment()
+		return
+	}
+
+	entry := newNeighborEntry(n, addr, n.state)
+	entry.mu.Lock()
+	entry.mu.neigh.LinkAddr = linkAddr
+	entry.setStateLocked(Static)
+	entry.dispatchAddEventLocked(n.nic.stack.nudDisp, ReasonStaticAdd)
+	entry.mu.Unlock()
+	n.mu.cache[addr] = entry
+	n.stats.EntriesCreated.Increment()
+}
+
+// entries returns a snapshot of all neighbor entries currently in the cache.
+func (n *neighborCache) entries() []NeighborEntry {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	entries := make([]NeighborEntry, 0, len(n.mu.cache))
+	for _, entry := range n.mu.cache {
+		entry.mu.Lock()
+		entries = append(entries, entry.mu.neigh)
+		entry.mu.Unlock()
+	}
+	return entries
+}
+
+// Neighbors returns a snapshot of every neighbor entry currently held in the
+// neighbor cache for the given NIC and network protocol, dynamic and Static
+// alike - a Static entry is reported with State: Static, same as it appears
+// in any NeighborEvent dispatched for it.
+func (s *Stack) Neighbors(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber) ([]NeighborEntry, tcpip.Error) {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return nil, &tcpip.ErrNotSupported{}
+	}
+
+	return linkRes.neigh.entries(), nil
+}
+
+// AddStaticNeighbor statically associates addr with linkAddr on the given
+// NIC and network protocol, analogous to Linux's `ip neigh add ... nud
+// permanent` and the "Static" NUD entry type used by Fuchsia's netstack3.
+// The resulting entry bypasses the RFC 4861 Neighbor Unreachability
+// Detection state machine: it never expires, is never probed, and is not
+// subject to cache eviction. Overwriting an existing dynamic entry cancels
+// any of its outstanding timers and flushes queued packets to linkAddr.
+func (s *Stack) AddStaticNeighbor(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, addr tcpip.Address, linkAddr tcpip.LinkAddress) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	linkRes.neigh.addStaticEntry(addr, linkAddr)
+	return nil
+}
+
+// RemoveNeighbor removes the neighbor entry for addr on the given NIC and
+// network protocol, whether it is dynamic or Static.
+func (s *Stack) RemoveNeighbor(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, addr tcpip.Address) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	linkRes.neigh.removeEntry(addr)
+	return nil
+}
+
+// RemoveStaticNeighbor removes the Static neighbor entry for addr on the
+// given NIC and network protocol, analogous to `ip neigh del ... nud
+// permanent`. Unlike RemoveNeighbor, it is a no-op if addr has no entry, or
+// has a dynamic rather than a Static one, so a caller that only means to
+// unpin an administratively-added neighbor cannot accidentally tear down one
+// the NUD state machine is still resolving.
+func (s *Stack) RemoveStaticNeighbor(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, addr tcpip.Address) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	linkRes.neigh.removeStaticEntry(addr)
+	return nil
+}
+
+// HandleUpperLevelConfirmation delivers a positive reachability confirmation
+// for addr, on the given NIC and network protocol, from an upper-layer
+// protocol above the NUD state machine - for example, a TCP endpoint that
+// just accepted an in-window ACK advancing SND.UNA, which per RFC 4861
+// section 7.3.1 is as good a sign of forward progress as a Neighbor
+// Advertisement. A neighbor in Stale, Delay, Probe or Unreachable is
+// resurrected straight to Reachable with its reachable timer restarted and no
+// probe is sent; a neighbor already Reachable simply has its timer
+// refreshed. It returns ErrUnknownNICID if id names no NIC, and
+// ErrNotSupported if the NIC has no link address resolver for protocol. It
+// is a no-op if addr has no entry in the neighbor cache.
+func (s *Stack) HandleUpperLevelConfirmation(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, addr tcpip.Address) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	linkRes.neigh.HandleUpperLevelConfirmation(addr)
+	return nil
+}
+
+// clearEntries removes every entry in the cache for which filter returns
+// true, skipping Static entries regardless of filter - like cache eviction,
+// clearing is a bulk, best-effort maintenance operation rather than an
+// explicit per-address RemoveNeighbor call, so it must not silently discard
+// entries a caller pinned on purpose. A nil filter matches every dynamic
+// entry.
+func (n *neighborCache) clearEntries(filter func(NeighborEntry) bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for addr, entry := range n.mu.cache {
+		entry.mu.Lock()
+		if entry.mu.neigh.State == Static || (filter != nil && !filter(entry.mu.neigh)) {
+			entry.mu.Unlock()
+			continue
+		}
+		entry.cancelJobLocked()
+		entry.dispatchRemoveEventLocked(n.nic.stack.nudDisp, ReasonRemove)
+		entry.failPendingPacketsLocked()
+		state := entry.mu.neigh.State
+		entry.mu.Unlock()
+		n.removeLocked(addr, state)
+	}
+}
+
+// ClearNeighbors removes every dynamic neighbor entry on the given NIC and
+// network protocol for which filter returns true, leaving Static entries
+// untouched. A nil filter clears every dynamic entry, analogous to `ip
+// neigh flush` without a match expression.
+func (s *Stack) ClearNeighbors(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, filter func(NeighborEntry) bool) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	linkRes.neigh.clearEntries(filter)
+	return nil
+}
+
+// SetNICDADDisabled sets whether Duplicate Address Detection is disabled for
+// the given NIC and network protocol, without affecting any other NIC's or
+// the stack-wide default. This is useful in environments where the attached
+// L2 is known to be point-to-point, or where DAD is actively harmful, e.g.
+// containerized sandboxes using Docker/libnetwork veth pairs.
+func (s *Stack) SetNICDADDisabled(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, disabled bool) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	linkRes.neigh.mu.Lock()
+	defer linkRes.neigh.mu.Unlock()
+	linkRes.neigh.mu.disableDAD = disabled
+	return nil
+}
+
+// DADDisabled reports whether Duplicate Address Detection has been disabled
+// for this cache's NIC and network protocol via Stack.SetNICDADDisabled. It
+// is consulted by the IPv6 NDP DAD implementation, which lives outside this
+// package, before it probes a tentative address.
+func (n *neighborCache) DADDisabled() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.mu.disableDAD
+}
+
+// SetNICForceStaleOnBringup sets whether new neighbor entries on the given
+// NIC and network protocol are created directly in the Stale state rather
+// than Unknown, skipping the Incomplete probing phase entirely, without
+// affecting any other NIC's or the stack-wide default.
+func (s *Stack) SetNICForceStaleOnBringup(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, force bool) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	linkRes.neigh.mu.Lock()
+	defer linkRes.neigh.mu.Unlock()
+	linkRes.neigh.mu.forceStaleOnBringup = force
+	return nil
+}
+
+// hasAddress reports whether addr is one of the network-layer addresses
+// assigned to this NIC, i.e. whether a gratuitous update advertising a new
+// link address for addr represents a potential conflict with an address
+// this stack itself owns.
+func (n *nic) hasAddress(addr tcpip.Address) bool {
+	for _, ep := range n.networkEndpoints {
+		if ep.MainAddress().Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// SendGratuitousNeighborAdvertisement broadcasts a reachability request for
+// addr, one of this NIC's own addresses, on every network protocol that
+// resolves link addresses on it - useful to proactively nudge neighbors'
+// caches after addr moves to a new link-layer address (e.g. following a
+// failover).
+//
+// This is a best-effort substitute for a genuine gratuitous ARP reply or
+// unsolicited Neighbor Advertisement: LinkAddressResolver, as defined in
+// this package, exposes no method for a protocol to send an unsolicited
+// reply of its own, only LinkAddressRequest, which always emits a
+// solicitation. A host that answers solicitations for its own address will
+// still end up updating neighbors' caches, but a neighbor that silently
+// discards solicitations for an address it isn't asking about will not be
+// reached by this.
+func (s *Stack) SendGratuitousNeighborAdvertisement(id tcpip.NICID, addr tcpip.Address) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+	if !nic.hasAddress(addr) {
+		return nil
+	}
+
+	for _, linkRes := range nic.linkAddrResolvers {
+		if err := linkRes.resolver.LinkAddressRequest(addr, addr, "" /* linkAddr */); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+var (
+	gcTestAddrStale      = tcpip.Address("\x00\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03")
+	gcTestAddrIncomplete = tcpip.Address("\x00\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x04")
+	gcTestAddrStatic     = tcpip.Address("\x00\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05")
+)
+
+// TestNeighborCacheGCEvictsOldestEligible verifies that gcLocked evicts only
+// the least-recently-used entry among those in Stale, Unreachable or Failed,
+// leaving Incomplete and Static entries - which are never eligible - alone,
+// once the cache exceeds MaxNeighborEntries.
+func TestNeighborCacheGCEvictsOldestEligible(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxNeighborEntries = 3
+	e, _, _, _ := entryTestSetup(c)
+
+	// entryTestAddr1 (e) starts in Unknown, set it to Stale and make it the
+	// oldest by backdating its lastUsedNanos.
+	e.mu.Lock()
+	e.setStateLocked(Stale)
+	e.mu.lastUsedNanos = 1
+	e.mu.Unlock()
+
+	e.cache.mu.Lock()
+	newer := newNeighborEntry(e.cache, gcTestAddrStale, e.cache.state)
+	newer.mu.Lock()
+	newer.setStateLocked(Stale)
+	newer.mu.lastUsedNanos = 100
+	newer.mu.Unlock()
+	e.cache.mu.cache[gcTestAddrStale] = newer
+
+	incomplete := newNeighborEntry(e.cache, gcTestAddrIncomplete, e.cache.state)
+	incomplete.mu.Lock()
+	incomplete.setStateLocked(Incomplete)
+	incomplete.mu.lastUsedNanos = 0
+	incomplete.mu.Unlock()
+	e.cache.mu.cache[gcTestAddrIncomplete] = incomplete
+	e.cache.mu.Unlock()
+
+	e.cache.addStaticEntry(gcTestAddrStatic, entryTestLinkAddr2)
+
+	e.cache.mu.Lock()
+	e.cache.gcLocked()
+	e.cache.mu.Unlock()
+
+	e.cache.mu.RLock()
+	defer e.cache.mu.RUnlock()
+	if _, ok := e.cache.mu.cache[entryTestAddr1]; ok {
+		t.Error("got the oldest Stale entry still present after gcLocked, want it evicted")
+	}
+	if _, ok := e.cache.mu.cache[gcTestAddrStale]; !ok {
+		t.Error("got the newer Stale entry evicted by gcLocked, want it to survive")
+	}
+	if _, ok := e.cache.mu.cache[gcTestAddrIncomplete]; !ok {
+		t.Error("got the Incomplete entry evicted by gcLocked, want it ineligible and surviving")
+	}
+	if _, ok := e.cache.mu.cache[gcTestAddrStatic]; !ok {
+		t.Error("got the Static entry evicted by gcLocked, want it ineligible and surviving")
+	}
+
+	if got, want := e.cache.stats.EntriesEvicted.Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.EntriesEvicted.Value() = %d, want = %d", got, want)
+	}
+}
+
+// TestNeighborCacheGCNoopUnderCapacity verifies that gcLocked evicts nothing
+// while the cache is within MaxNeighborEntries.
+func TestNeighborCacheGCNoopUnderCapacity(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxNeighborEntries = 2
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.setStateLocked(Stale)
+	e.mu.Unlock()
+
+	e.cache.mu.Lock()
+	e.cache.gcLocked()
+	e.cache.mu.Unlock()
+
+	e.cache.mu.RLock()
+	defer e.cache.mu.RUnlock()
+	if _, ok := e.cache.mu.cache[entryTestAddr1]; !ok {
+		t.Error("got the only entry evicted by gcLocked while under capacity, want it to survive")
+	}
+	if got, want := e.cache.stats.EntriesEvicted.Value(), uint64(0); got != want {
+		t.Errorf("got e.cache.stats.EntriesEvicted.Value() = %d, want = %d", got, want)
+	}
+}
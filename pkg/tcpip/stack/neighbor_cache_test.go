@@ -0,0 +1,80 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "testing"
+
+// TestNeighborCacheClearEntriesSkipsStatic verifies that clearEntries never
+// removes a Static entry, even with a nil (match-everything) filter.
+func TestNeighborCacheClearEntriesSkipsStatic(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.cache.mu.cache[entryTestAddr1] = e
+	e.cache.mu.Unlock()
+	e.cache.addStaticEntry(entryTestAddr1, entryTestLinkAddr1)
+
+	e.cache.mu.Lock()
+	dynamic := newNeighborEntry(e.cache, entryTestAddr2, e.cache.state)
+	dynamic.mu.Lock()
+	dynamic.setStateLocked(Stale)
+	dynamic.mu.Unlock()
+	e.cache.mu.cache[entryTestAddr2] = dynamic
+	e.cache.mu.Unlock()
+
+	e.cache.clearEntries(nil)
+
+	e.cache.mu.RLock()
+	defer e.cache.mu.RUnlock()
+	if _, ok := e.cache.mu.cache[entryTestAddr1]; !ok {
+		t.Error("got no Static entry for entryTestAddr1 after ClearNeighbors, want it to survive")
+	}
+	if _, ok := e.cache.mu.cache[entryTestAddr2]; ok {
+		t.Error("got a dynamic entry for entryTestAddr2 after ClearNeighbors, want it removed")
+	}
+}
+
+// TestNeighborCacheClearEntriesHonorsFilter verifies that clearEntries only
+// removes dynamic entries for which filter returns true.
+func TestNeighborCacheClearEntriesHonorsFilter(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.setStateLocked(Stale)
+	e.mu.Unlock()
+
+	e.cache.mu.Lock()
+	kept := newNeighborEntry(e.cache, entryTestAddr2, e.cache.state)
+	kept.mu.Lock()
+	kept.setStateLocked(Stale)
+	kept.mu.Unlock()
+	e.cache.mu.cache[entryTestAddr2] = kept
+	e.cache.mu.Unlock()
+
+	e.cache.clearEntries(func(n NeighborEntry) bool {
+		return n.Addr == entryTestAddr1
+	})
+
+	e.cache.mu.RLock()
+	defer e.cache.mu.RUnlock()
+	if _, ok := e.cache.mu.cache[entryTestAddr1]; ok {
+		t.Error("got an entry for entryTestAddr1 after a filtered ClearNeighbors, want it removed")
+	}
+	if _, ok := e.cache.mu.cache[entryTestAddr2]; !ok {
+		t.Error("got no entry for entryTestAddr2 after a filtered ClearNeighbors, want it to survive")
+	}
+}
@@ -0,0 +1,626 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// immediateDuration is a duration of zero for scheduling jobs that should
+// run immediately. Jobs are still scheduled on the clock (rather than being
+// run inline) so that entry mutations always happen on the same goroutine
+// and in the order observed by the rest of the NUD state machine.
+const immediateDuration time.Duration = 0
+
+// neighborEntry implements a neighbor entry's individual node behavior, as
+// per RFC 4861 section 7.3.3. Neighbor Unreachability Detection operates in
+// parallel with the sending of packets to a neighbor, and is defined in
+// terms of a state machine per destination. All entries start in the
+// Unknown state and may transition as follows:
+//
+// The state transitions are documented in the table at the top of
+// neighbor_entry_test.go.
+type neighborEntry struct {
+	cache *neighborCache
+
+	// nudState points to the shared NUD state, used to compute the reachable
+	// time for this entry's cache.
+	nudState *NUDState
+
+	mu struct {
+		sync.Mutex
+
+		neigh NeighborEntry
+
+		// isRouter indicates whether or not the neighbor is a router. This
+		// field is only relevant for IPv6.
+		isRouter bool
+
+		// timer is the next scheduled timer for this entry on the cache's
+		// shared neighborTimers wheel, used to send reachability probes or
+		// to expire an entry's Reachable state. At most one timer is ever
+		// outstanding per entry.
+		timer *timerHandle
+
+		// probesSent is the number of reachability probes sent since
+		// entering the Incomplete, Probe or Unreachable state.
+		probesSent uint32
+
+		// pending holds packets queued for transmission while the entry is
+		// in the Incomplete, Probe, Delay or Unreachable states, bounded by
+		// NUDConfigurations.MaxPendingPacketsPerNeighbor. The oldest packet
+		// is dropped to make room for a new one once the bound is reached.
+		pending []queuedPacket
+
+		// lastUsedNanos is the system time, in nanoseconds, at which this
+		// entry was last used to send a packet or last had its reachability
+		// positively confirmed. It is distinct from neigh.UpdatedAtNanos,
+		// which is touched by every state-machine transition, including
+		// ones (like a reachable timer elapsing into Stale) that don't
+		// reflect any actual traffic to the neighbor. neighborCache's LRU
+		// garbage collector uses this to pick an eviction candidate among
+		// entries eligible for it.
+		lastUsedNanos int64
+	}
+}
+
+// queuedPacket is a packet held on a neighborEntry awaiting resolution of
+// the entry's link address. done is called exactly once the packet leaves
+// the queue: with the resolved link address on success, or a non-nil err
+// (typically *tcpip.ErrHostUnreachable) if resolution failed.
+type queuedPacket struct {
+	pkt  *PacketBuffer
+	done func(linkAddr tcpip.LinkAddress, err tcpip.Error)
+}
+
+// newNeighborEntry creates a neighbor cache entry starting in the Unknown
+// state.
+func newNeighborEntry(cache *neighborCache, remoteAddr tcpip.Address, nudState *NUDState) *neighborEntry {
+	e := &neighborEntry{
+		cache:    cache,
+		nudState: nudState,
+	}
+	e.mu.neigh = NeighborEntry{
+		Addr:  remoteAddr,
+		State: Unknown,
+	}
+	e.mu.lastUsedNanos = cache.nic.stack.clock.NowNanoseconds()
+	cache.stats.EntriesByState[Unknown].Increment()
+	return e
+}
+
+// dispatchAddEventLocked dispatches an Added event for the current state of
+// the entry to the NUD dispatcher and to every subscriber on the cache.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) dispatchAddEventLocked(nudDisp NUDDispatcher, reason NeighborEventReason) {
+	e.mu.neigh.UpdatedAtNanos = e.cache.nic.stack.clock.NowNanoseconds()
+	if nudDisp != nil {
+		nudDisp.OnNeighborAdded(e.cache.nic.id, e.mu.neigh)
+	}
+	e.cache.notifySubscribersLocked(NeighborEventAdded, reason, e.mu.neigh)
+}
+
+func (e *neighborEntry) dispatchChangeEventLocked(nudDisp NUDDispatcher, reason NeighborEventReason) {
+	e.mu.neigh.UpdatedAtNanos = e.cache.nic.stack.clock.NowNanoseconds()
+	if nudDisp != nil {
+		nudDisp.OnNeighborChanged(e.cache.nic.id, e.mu.neigh)
+	}
+	e.cache.notifySubscribersLocked(NeighborEventChanged, reason, e.mu.neigh)
+}
+
+func (e *neighborEntry) dispatchRemoveEventLocked(nudDisp NUDDispatcher, reason NeighborEventReason) {
+	if nudDisp != nil {
+		nudDisp.OnNeighborRemoved(e.cache.nic.id, e.mu.neigh)
+	}
+	e.cache.notifySubscribersLocked(NeighborEventRemoved, reason, e.mu.neigh)
+}
+
+// cancelJobLocked cancels any pending timer for this entry, preventing it
+// from firing.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) cancelJobLocked() {
+	if e.mu.timer != nil {
+		e.cache.timers.cancel(e.mu.timer)
+		e.mu.timer = nil
+	}
+}
+
+// scheduleJobLocked cancels any timer already pending for this entry and
+// schedules fn to run after d elapses on the cache's shared timer wheel.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) scheduleJobLocked(d time.Duration, fn func()) {
+	e.cancelJobLocked()
+	e.mu.timer = e.cache.timers.schedule(d, fn)
+}
+
+// setStateLocked transitions the entry to the provided state immediately,
+// recording the transition in the cache's NUDStats. On a transition into
+// Reachable or Stale, every packet still pending on the entry is delivered to
+// its current link address. Other follow-up actions, such as probing or
+// scheduling, must be handled by the caller.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) setStateLocked(next NeighborState) {
+	prev := e.mu.neigh.State
+	e.mu.neigh.State = next
+	e.cache.stats.transition(prev, next)
+	if next == Reachable || next == Stale {
+		e.drainPendingPacketsLocked()
+	}
+}
+
+// touchLastUsedLocked records that the entry was just used to send a packet
+// or had its reachability positively confirmed, for neighborCache's LRU
+// garbage collector to consult.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) touchLastUsedLocked() {
+	e.mu.lastUsedNanos = e.cache.nic.stack.clock.NowNanoseconds()
+}
+
+// handlePacketQueuedLocked advances the entry's state machine according to
+// the event of a packet being queued for transmission to the entry's
+// address, as per RFC 4861 section 7.3.3.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) handlePacketQueuedLocked(localAddr tcpip.Address) {
+	e.touchLastUsedLocked()
+	switch e.mu.neigh.State {
+	case Unknown, Failed:
+		e.mu.neigh.LinkAddr = ""
+		e.setStateLocked(Incomplete)
+		e.mu.probesSent = 0
+		e.dispatchAddEventLocked(e.cache.nic.stack.nudDisp, ReasonPacketQueued)
+		e.scheduleJobLocked(immediateDuration, func() {
+			e.cache.mu.Lock()
+			defer e.cache.mu.Unlock()
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.sendProbeLocked(localAddr)
+		})
+
+	case Stale, Unreachable:
+		// An entry in Unreachable remains in the cache with its previously
+		// resolved link address and unicast probing already under way, so a
+		// queued packet demotes it to Delay to give upper-layer protocols a
+		// chance to confirm reachability, rather than resurrecting
+		// Incomplete and discarding the link address already known.
+		e.setStateLocked(Delay)
+		e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonPacketQueued)
+		e.scheduleJobLocked(e.nudState.Config().DelayFirstProbeTime, func() {
+			e.cache.mu.Lock()
+			defer e.cache.mu.Unlock()
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if e.mu.neigh.State != Delay {
+				return
+			}
+			e.mu.probesSent = 0
+			e.setStateLocked(Probe)
+			e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonTimeout)
+			e.sendProbeLocked(localAddr)
+		})
+
+	case Static:
+		// Static entries do not participate in the NUD state machine; a
+		// queued packet has nothing to trigger.
+
+	case Incomplete, Reachable, Delay, Probe:
+		// Nothing to do; either already probing or still considered
+		// reachable.
+	}
+}
+
+// sendProbeLocked sends a multicast or unicast reachability probe for the
+// entry's address and schedules a retransmission. Once the maximum number of
+// probes has been sent, an Incomplete entry transitions to Failed, while a
+// Probe entry transitions to Unreachable per RFC 7048 and continues unicast
+// probing at a backoff interval via sendUnreachableProbeLocked, rather than
+// being deleted outright.
+//
+// Precondition: e.cache.mu and e.mu MUST both be locked for writing, in that
+// order, since exhausting maxProbes removes the entry from the cache.
+func (e *neighborEntry) sendProbeLocked(localAddr tcpip.Address) {
+	config := e.nudState.Config()
+
+	var maxProbes uint32
+	var remoteLinkAddr tcpip.LinkAddress
+	switch e.mu.neigh.State {
+	case Incomplete:
+		maxProbes = config.MaxMulticastProbes
+	case Probe:
+		maxProbes = config.MaxUnicastProbes
+		remoteLinkAddr = e.mu.neigh.LinkAddr
+	default:
+		return
+	}
+
+	if e.mu.probesSent >= maxProbes {
+		if e.mu.neigh.State == Probe {
+			e.mu.probesSent = 0
+			e.setStateLocked(Unreachable)
+			e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonTimeout)
+			e.sendUnreachableProbeLocked(localAddr)
+			return
+		}
+		e.setStateLocked(Failed)
+		e.dispatchRemoveEventLocked(e.cache.nic.stack.nudDisp, ReasonTimeout)
+		e.failPendingPacketsLocked()
+		e.cache.removeLocked(e.mu.neigh.Addr, Failed)
+		return
+	}
+
+	e.mu.probesSent++
+	if err := e.cache.linkRes.LinkAddressRequest(e.mu.neigh.Addr, localAddr, remoteLinkAddr); err != nil {
+		e.cache.stats.ProbesDropped.Increment()
+	} else if len(remoteLinkAddr) == 0 {
+		e.cache.stats.MulticastProbesSent.Increment()
+	} else {
+		e.cache.stats.UnicastProbesSent.Increment()
+	}
+
+	e.scheduleJobLocked(config.RetransmitTimer, func() {
+		e.cache.mu.Lock()
+		defer e.cache.mu.Unlock()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.sendProbeLocked(localAddr)
+	})
+}
+
+// sendUnreachableProbeLocked sends a unicast reachability probe for an entry
+// in the Unreachable state and schedules the next probe after an
+// exponentially increasing interval - RetransmitTimer multiplied by
+// UnreachableBackoffMultiplier for each unanswered probe, capped at
+// MaxUnreachableInterval and randomized by RetransmitJitter - as per RFC
+// 7048. Once MaxUnreachableProbes have gone unanswered, the entry
+// transitions to Failed and is removed from the cache.
+//
+// Precondition: e.cache.mu and e.mu MUST both be locked for writing, in that
+// order, since exhausting MaxUnreachableProbes removes the entry from the
+// cache.
+func (e *neighborEntry) sendUnreachableProbeLocked(localAddr tcpip.Address) {
+	config := e.nudState.Config()
+
+	if e.mu.probesSent >= config.MaxUnreachableProbes {
+		e.setStateLocked(Failed)
+		e.dispatchRemoveEventLocked(e.cache.nic.stack.nudDisp, ReasonTimeout)
+		e.failPendingPacketsLocked()
+		e.cache.removeLocked(e.mu.neigh.Addr, Failed)
+		return
+	}
+
+	e.mu.probesSent++
+	if err := e.cache.linkRes.LinkAddressRequest(e.mu.neigh.Addr, localAddr, e.mu.neigh.LinkAddr); err != nil {
+		e.cache.stats.ProbesDropped.Increment()
+	} else {
+		e.cache.stats.UnicastProbesSent.Increment()
+	}
+
+	interval := config.RetransmitTimer
+	for i := uint32(1); i < e.mu.probesSent; i++ {
+		interval *= time.Duration(config.UnreachableBackoffMultiplier)
+		if interval >= config.MaxUnreachableInterval {
+			break
+		}
+	}
+	if interval > config.MaxUnreachableInterval {
+		interval = config.MaxUnreachableInterval
+	}
+	if interval = time.Duration(float32(interval) * e.nudState.backoffJitterMultiplier(config.RetransmitJitter)); interval > config.MaxUnreachableInterval {
+		interval = config.MaxUnreachableInterval
+	} else if interval < minimumRetransmitTimer {
+		interval = minimumRetransmitTimer
+	}
+
+	e.scheduleJobLocked(interval, func() {
+		e.cache.mu.Lock()
+		defer e.cache.mu.Unlock()
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.sendUnreachableProbeLocked(localAddr)
+	})
+}
+
+// handleProbeLocked advances the entry's state machine according to the
+// receipt of a reachability probe, as per RFC 4861 section 7.2.3.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) handleProbeLocked(remoteLinkAddr tcpip.LinkAddress) {
+	switch e.mu.neigh.State {
+	case Unknown:
+		e.mu.neigh.LinkAddr = remoteLinkAddr
+		e.setStateLocked(Stale)
+		e.dispatchAddEventLocked(e.cache.nic.stack.nudDisp, ReasonProbe)
+
+	case Incomplete:
+		e.mu.neigh.LinkAddr = remoteLinkAddr
+		e.setStateLocked(Stale)
+		e.cancelJobLocked()
+		e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonProbe)
+		e.drainPendingPacketsLocked()
+
+	case Reachable, Delay, Probe:
+		if e.mu.neigh.LinkAddr != remoteLinkAddr {
+			e.mu.neigh.LinkAddr = remoteLinkAddr
+			e.setStateLocked(Stale)
+			e.cancelJobLocked()
+			e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonProbe)
+		}
+
+	case Unreachable:
+		// Any probe from an Unreachable neighbor resurrects it, per RFC 7048,
+		// even one advertising the link address already cached.
+		e.mu.neigh.LinkAddr = remoteLinkAddr
+		e.setStateLocked(Stale)
+		e.cancelJobLocked()
+		e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonProbe)
+
+	case Stale:
+		if e.mu.neigh.LinkAddr != remoteLinkAddr {
+			e.mu.neigh.LinkAddr = remoteLinkAddr
+			e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonProbe)
+		}
+
+	case Static, Failed:
+		// Static entries never change state, and reachability probes are
+		// answered without touching the cached state. Failed entries are
+		// removed from the cache and should not be seen here, but guard
+		// against it regardless.
+	}
+}
+
+// handleConfirmationLocked advances the entry's state machine according to
+// the receipt of a reachability confirmation, as per RFC 4861 section 7.2.5.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) handleConfirmationLocked(linkAddr tcpip.LinkAddress, flags ReachabilityConfirmationFlags) {
+	if flags.Solicited {
+		e.cache.stats.SolicitedConfirmations.Increment()
+	} else {
+		e.cache.stats.UnsolicitedConfirmations.Increment()
+	}
+
+	switch e.mu.neigh.State {
+	case Incomplete:
+		if len(linkAddr) == 0 {
+			return
+		}
+		e.mu.neigh.LinkAddr = linkAddr
+		e.mu.isRouter = flags.IsRouter
+		e.cancelJobLocked()
+		if flags.Solicited {
+			e.setStateLocked(Reachable)
+			e.touchLastUsedLocked()
+			e.scheduleReachableTimerLocked()
+		} else {
+			e.setStateLocked(Stale)
+		}
+		e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonConfirmation)
+		e.drainPendingPacketsLocked()
+
+	case Reachable, Stale, Delay, Probe, Unreachable:
+		isRouterChanged := e.mu.isRouter != flags.IsRouter
+		e.mu.isRouter = flags.IsRouter
+
+		sameAddr := len(linkAddr) == 0 || linkAddr == e.mu.neigh.LinkAddr
+		if !flags.Override && !sameAddr {
+			if e.mu.neigh.State == Reachable || e.mu.neigh.State == Unreachable {
+				e.setStateLocked(Stale)
+				e.cancelJobLocked()
+				e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonConfirmation)
+			}
+			return
+		}
+
+		if flags.Override && !sameAddr {
+			oldLinkAddr := e.mu.neigh.LinkAddr
+			e.mu.neigh.LinkAddr = linkAddr
+			e.checkGratuitousConflictLocked(oldLinkAddr, linkAddr)
+			if !flags.Solicited {
+				e.setStateLocked(Stale)
+				e.cancelJobLocked()
+				e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonConfirmation)
+				return
+			}
+		}
+
+		if flags.Solicited {
+			e.setStateLocked(Reachable)
+			e.touchLastUsedLocked()
+			e.cancelJobLocked()
+			e.scheduleReachableTimerLocked()
+			e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonConfirmation)
+			return
+		}
+
+		// An unsolicited override received while Reachable means the
+		// neighbor's reachability can no longer be assumed without a fresh
+		// probe, per RFC 4861 section 7.2.5 - even if the advertised address
+		// matches what is already cached. The same applies to an entry
+		// already in Unreachable: any reply at all, per RFC 7048, resurrects
+		// it rather than leaving unicast backoff probing to continue.
+		if (flags.Override && e.mu.neigh.State == Reachable) || e.mu.neigh.State == Unreachable {
+			e.setStateLocked(Stale)
+			e.cancelJobLocked()
+			e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonConfirmation)
+			return
+		}
+
+		if isRouterChanged {
+			e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonConfirmation)
+		}
+
+	case Unknown, Static, Failed:
+		// Confirmations for Unknown neighbors do not create an entry.
+		// Static entries are immune to confirmations. Failed entries are
+		// removed from the cache.
+	}
+}
+
+// checkGratuitousConflictLocked reports a potential duplicate address to the
+// NUD dispatcher if addr is one that this stack itself owns, since a
+// gratuitous update that changes its cached link address indicates another
+// host on the link believes it owns the same address.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) checkGratuitousConflictLocked(oldLinkAddr, newLinkAddr tcpip.LinkAddress) {
+	nudDisp := e.cache.nic.stack.nudDisp
+	if nudDisp == nil {
+		return
+	}
+	if !e.cache.nic.hasAddress(e.mu.neigh.Addr) {
+		return
+	}
+	nudDisp.OnNeighborLinkAddressConflict(e.cache.nic.id, e.mu.neigh.Addr, oldLinkAddr, newLinkAddr)
+}
+
+// handleGratuitousLocked advances the entry's state machine according to the
+// receipt of a gratuitous ARP reply or an unsolicited NA with the Override
+// flag set, for an address that already has an entry in the cache. It is
+// equivalent to handleConfirmationLocked with Solicited and Override set
+// accordingly: if the advertised link address differs from the one cached,
+// the entry transitions to Stale with the new address.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) handleGratuitousLocked(linkAddr tcpip.LinkAddress, isRouter bool) {
+	e.handleConfirmationLocked(linkAddr, ReachabilityConfirmationFlags{
+		Solicited: false,
+		Override:  true,
+		IsRouter:  isRouter,
+	})
+}
+
+// handleUpperLevelConfirmationLocked advances the entry's state machine
+// according to a positive reachability confirmation delivered from an
+// upper-layer protocol (e.g. a newly-acknowledged TCP segment), as per RFC
+// 4861 section 7.3.1.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) handleUpperLevelConfirmationLocked() {
+	switch e.mu.neigh.State {
+	case Reachable, Stale, Delay, Probe, Unreachable:
+		e.cache.stats.UpperLayerConfirmations.Increment()
+		e.setStateLocked(Reachable)
+		e.touchLastUsedLocked()
+		e.cancelJobLocked()
+		e.scheduleReachableTimerLocked()
+		e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonUpperLayerConfirmation)
+	case Unknown, Incomplete, Static, Failed:
+		// An upper-layer protocol cannot confirm reachability for an
+		// address that has not yet been resolved, and Static entries are
+		// immune to the state machine.
+	}
+}
+
+// scheduleReachableTimerLocked schedules the timer that demotes the entry
+// from Reachable to Stale once the cache's shared reachable time elapses.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) scheduleReachableTimerLocked() {
+	e.scheduleJobLocked(e.nudState.ReachableTime(), func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.mu.neigh.State != Reachable {
+			return
+		}
+		e.setStateLocked(Stale)
+		e.dispatchChangeEventLocked(e.cache.nic.stack.nudDisp, ReasonTimeout)
+	})
+}
+
+// queuePacketLocked queues pkt for transmission once the entry's link
+// address is known, calling done with the outcome exactly once the packet
+// leaves the queue. If the entry is not awaiting resolution (i.e. it is
+// already Reachable, Static, or has otherwise left the queueing states),
+// done is called back immediately.
+//
+// If the queue is already at NUDConfigurations.MaxPendingPacketsPerNeighbor,
+// the oldest pending packet is dropped - and failed with
+// *tcpip.ErrHostUnreachable - to make room for pkt, and
+// NUDStats.PendingPacketsDropped is incremented.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) queuePacketLocked(pkt *PacketBuffer, done func(linkAddr tcpip.LinkAddress, err tcpip.Error)) {
+	switch e.mu.neigh.State {
+	case Reachable, Static:
+		if done != nil {
+			done(e.mu.neigh.LinkAddr, nil)
+		}
+		return
+	case Incomplete, Probe, Delay, Unreachable:
+	default:
+		if done != nil {
+			done("", &tcpip.ErrHostUnreachable{})
+		}
+		return
+	}
+
+	if max := int(e.nudState.Config().MaxPendingPacketsPerNeighbor); max > 0 && len(e.mu.pending) >= max {
+		dropped := e.mu.pending[0]
+		e.mu.pending = e.mu.pending[1:]
+		e.cache.stats.PendingPacketsDropped.Increment()
+		if dropped.done != nil {
+			dropped.done("", &tcpip.ErrHostUnreachable{})
+		}
+	}
+
+	e.mu.pending = append(e.mu.pending, queuedPacket{pkt: pkt, done: done})
+	e.mu.neigh.PendingPackets = len(e.mu.pending)
+}
+
+// drainPendingPacketsLocked delivers the outcome of address resolution to
+// every packet queued on the entry and empties the queue.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) drainPendingPacketsLocked() {
+	pending := e.mu.pending
+	e.mu.pending = nil
+	e.mu.neigh.PendingPackets = 0
+	for _, p := range pending {
+		if p.done != nil {
+			p.done(e.mu.neigh.LinkAddr, nil)
+		}
+	}
+}
+
+// failPendingPacketsLocked fails every packet queued on the entry with
+// *tcpip.ErrHostUnreachable, mirroring the treatment of in-flight sends when
+// address resolution fails outright, empties the queue, and increments
+// NUDStats.PendingPacketsFailed by the number of packets failed - distinct
+// from NUDStats.ResolutionFailed, which counts the one transition into
+// Failed regardless of how many packets were queued on the entry at the
+// time.
+//
+// Precondition: e.mu MUST be locked for writing.
+func (e *neighborEntry) failPendingPacketsLocked() {
+	pending := e.mu.pending
+	e.mu.pending = nil
+	e.mu.neigh.PendingPackets = 0
+	if len(pending) != 0 {
+		e.cache.stats.PendingPacketsFailed.IncrementBy(uint64(len(pending)))
+	}
+	for _, p := range pending {
+		if p.done != nil {
+			p.done("", &tcpip.ErrHostUnreachable{})
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// TestNUDStateBackoffJitterMultiplier verifies that
+// NUDState.backoffJitterMultiplier returns exactly 1 when jitter is 0, and
+// otherwise returns a value bounded by [1-jitter, 1+jitter].
+func TestNUDStateBackoffJitterMultiplier(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	if got, want := e.nudState.backoffJitterMultiplier(0), float32(1); got != want {
+		t.Errorf("got backoffJitterMultiplier(0) = %v, want = %v", got, want)
+	}
+
+	const jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := e.nudState.backoffJitterMultiplier(jitter)
+		if got < 1-jitter || got > 1+jitter {
+			t.Fatalf("got backoffJitterMultiplier(%v) = %v, want within [%v, %v]", jitter, got, 1-jitter, 1+jitter)
+		}
+	}
+}
+
+// TestEntryUnreachableBackoffHonorsRetransmitJitter verifies that the
+// Unreachable-state probe interval computed by sendUnreachableProbeLocked
+// stays within the bounds implied by RetransmitJitter, both below and above
+// the unjittered backoff interval.
+func TestEntryUnreachableBackoffHonorsRetransmitJitter(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxUnreachableProbes = 100
+	c.UnreachableBackoffMultiplier = 2
+	c.MaxUnreachableInterval = 100 * c.RetransmitTimer
+	c.RetransmitJitter = 0.5
+	e, _, linkRes, clock := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Unreachable)
+	e.mu.probesSent = 0
+	e.sendUnreachableProbeLocked(entryTestAddr2)
+	e.mu.Unlock()
+	e.cache.mu.Unlock()
+
+	// The unjittered interval for the second probe is RetransmitTimer, so
+	// the jittered interval can never exceed (1+0.5)*RetransmitTimer.
+	maxInterval := c.RetransmitTimer + c.RetransmitTimer/2
+	clock.Advance(maxInterval)
+
+	linkRes.mu.Lock()
+	got := len(linkRes.probes)
+	linkRes.mu.Unlock()
+	if got != 2 {
+		t.Errorf("got %d probes sent after waiting the maximum possible jittered interval, want = 2", got)
+	}
+}
+
+// TestEntryProbeToUnreachableIncrementsProbesDropped verifies that a failed
+// LinkAddressRequest increments ProbesDropped instead of
+// UnicastProbesSent/MulticastProbesSent.
+func TestEntryProbeToUnreachableIncrementsProbesDropped(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, linkRes, _ := entryTestSetup(c)
+
+	linkRes.mu.Lock()
+	linkRes.returnErr = &tcpip.ErrNotSupported{}
+	linkRes.mu.Unlock()
+
+	e.cache.mu.Lock()
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Probe)
+	e.mu.probesSent = 0
+	e.sendProbeLocked(entryTestAddr2)
+	e.mu.Unlock()
+	e.cache.mu.Unlock()
+
+	if got := e.cache.stats.ProbesDropped.Value(); got != 1 {
+		t.Errorf("got ProbesDropped = %d, want = 1", got)
+	}
+	if got := e.cache.stats.UnicastProbesSent.Value(); got != 0 {
+		t.Errorf("got UnicastProbesSent = %d, want = 0", got)
+	}
+
+	linkRes.mu.Lock()
+	gotProbes := len(linkRes.probes)
+	linkRes.mu.Unlock()
+	if gotProbes != 0 {
+		t.Errorf("got %d recorded probes, want = 0 since LinkAddressRequest returned an error", gotProbes)
+	}
+}
+
+// TestEntryProbeToUnreachableIncrementsUnreachableDeclarations verifies that
+// UnreachableDeclarations increments exactly once when a Probe entry
+// exhausts MaxUnicastProbes and transitions to Unreachable.
+func TestEntryProbeToUnreachableIncrementsUnreachableDeclarations(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxUnicastProbes = 2
+	e, _, _, clock := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Probe)
+	e.mu.probesSent = 0
+	e.sendProbeLocked(entryTestAddr2)
+	e.mu.Unlock()
+	e.cache.mu.Unlock()
+
+	if got := e.cache.stats.UnreachableDeclarations.Value(); got != 0 {
+		t.Fatalf("got UnreachableDeclarations = %d before MaxUnicastProbes elapsed, want = 0", got)
+	}
+
+	for i := uint32(0); i < c.MaxUnicastProbes; i++ {
+		clock.Advance(c.RetransmitTimer)
+	}
+
+	e.mu.Lock()
+	gotState := e.mu.neigh.State
+	e.mu.Unlock()
+	if gotState != Unreachable {
+		t.Fatalf("got e.mu.neigh.State = %q, want = %q", gotState, Unreachable)
+	}
+	if got := e.cache.stats.UnreachableDeclarations.Value(); got != 1 {
+		t.Errorf("got UnreachableDeclarations = %d, want = 1", got)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "testing"
+
+// TestEntryReachableToStaleWhenUnsolicitedOverrideSameAddress verifies that
+// an unsolicited, overriding reachability confirmation demotes a Reachable
+// entry to Stale even when the advertised link address matches what is
+// already cached, per RFC 4861 section 7.2.5.
+func TestEntryReachableToStaleWhenUnsolicitedOverrideSameAddress(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: true,
+	})
+	if e.mu.neigh.State != Reachable {
+		t.Fatalf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Reachable)
+	}
+
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: false,
+		Override:  true,
+	})
+	if e.mu.neigh.State != Stale {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Stale)
+	}
+	e.mu.Unlock()
+}
+
+// TestEntryReachableStaysReachableWhenUnsolicitedNonOverride verifies that a
+// plain (non-Override) unsolicited confirmation with a matching address
+// does not perturb a Reachable entry.
+func TestEntryReachableStaysReachableWhenUnsolicitedNonOverride(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: true,
+	})
+
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: false,
+		Override:  false,
+	})
+	if e.mu.neigh.State != Reachable {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Reachable)
+	}
+	e.mu.Unlock()
+}
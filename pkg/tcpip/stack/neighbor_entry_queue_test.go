@@ -0,0 +1,149 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// TestEntryPendingPacketQueueBounded verifies that queuePacketLocked drops
+// the oldest pending packet, failing it with *tcpip.ErrHostUnreachable, once
+// MaxPendingPacketsPerNeighbor is reached.
+func TestEntryPendingPacketQueueBounded(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxPendingPacketsPerNeighbor = 2
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+
+	var results []tcpip.Error
+	var order []int
+	done := func(i int) func(tcpip.LinkAddress, tcpip.Error) {
+		return func(_ tcpip.LinkAddress, err tcpip.Error) {
+			order = append(order, i)
+			results = append(results, err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		e.queuePacketLocked(nil /* pkt */, done(i))
+	}
+
+	if got, want := len(e.mu.pending), 2; got != want {
+		t.Fatalf("got len(e.mu.pending) = %d, want = %d", got, want)
+	}
+	e.mu.Unlock()
+
+	if len(order) != 1 || order[0] != 0 {
+		t.Fatalf("got order = %v, want the oldest packet (index 0) to be dropped first", order)
+	}
+	if len(results) != 1 || results[0] == nil {
+		t.Fatalf("got results = %v, want the dropped packet to fail with a non-nil error", results)
+	}
+
+	dropped := e.cache.stats.PendingPacketsDropped.Value()
+	if dropped != 1 {
+		t.Errorf("got pendingPacketsDropped = %d, want = 1", dropped)
+	}
+
+	e.mu.Lock()
+	if got, want := e.mu.neigh.PendingPackets, 2; got != want {
+		t.Errorf("got e.mu.neigh.PendingPackets = %d, want = %d", got, want)
+	}
+	e.mu.Unlock()
+}
+
+// TestEntryPendingPacketQueueDrainsOnReachable verifies that every packet
+// queued while Incomplete is delivered to its callback once the entry
+// becomes Reachable.
+func TestEntryPendingPacketQueueDrainsOnReachable(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, clock := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+
+	var delivered []tcpip.LinkAddress
+	for i := 0; i < 3; i++ {
+		e.queuePacketLocked(nil /* pkt */, func(linkAddr tcpip.LinkAddress, err tcpip.Error) {
+			if err != nil {
+				t.Errorf("got unexpected packet failure: %s", err)
+			}
+			delivered = append(delivered, linkAddr)
+		})
+	}
+	e.mu.Unlock()
+
+	runImmediatelyScheduledJobs(clock)
+
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: true,
+	})
+	if len(e.mu.pending) != 0 {
+		t.Errorf("got len(e.mu.pending) = %d, want = 0 after drain", len(e.mu.pending))
+	}
+	e.mu.Unlock()
+
+	if len(delivered) != 3 {
+		t.Fatalf("got %d delivered packets, want 3", len(delivered))
+	}
+	for _, linkAddr := range delivered {
+		if linkAddr != entryTestLinkAddr1 {
+			t.Errorf("got delivered linkAddr = %q, want = %q", linkAddr, entryTestLinkAddr1)
+		}
+	}
+}
+
+// TestEntryPendingPacketQueueFailsOnFailed verifies that every packet
+// remaining in the queue is failed with *tcpip.ErrHostUnreachable when the
+// entry transitions to Failed.
+func TestEntryPendingPacketQueueFailsOnFailed(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxMulticastProbes = 1
+	e, _, _, clock := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+
+	var failures int
+	e.queuePacketLocked(nil /* pkt */, func(_ tcpip.LinkAddress, err tcpip.Error) {
+		if err == nil {
+			t.Errorf("got nil error, want *tcpip.ErrHostUnreachable")
+		}
+		failures++
+	})
+	e.mu.Unlock()
+
+	runImmediatelyScheduledJobs(clock)
+	clock.Advance(c.RetransmitTimer)
+
+	if failures != 1 {
+		t.Errorf("got %d failed packets, want 1", failures)
+	}
+
+	if got, want := e.cache.stats.PendingPacketsFailed.Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.PendingPacketsFailed.Value() = %d, want = %d", got, want)
+	}
+
+	e.mu.Lock()
+	if got, want := e.mu.neigh.PendingPackets, 0; got != want {
+		t.Errorf("got e.mu.neigh.PendingPackets = %d, want = %d", got, want)
+	}
+	e.mu.Unlock()
+}
@@ -0,0 +1,363 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// The following tests extend the state-transition table at the top of
+// neighbor_entry_test.go with the Static state, which is exempt from the RFC
+// 4861 Neighbor Unreachability Detection state machine.
+//
+// | From       | To         | Cause                                      | Update   | Action     | Event   |
+// | ========== | ========== | ========================================== | ======== | ===========| ======= |
+// | n/a        | Static     | AddStaticNeighbor                          | LinkAddr |            | Added   |
+// | Static     | Static     | AddStaticNeighbor (re-pin)                 | LinkAddr |            | Changed |
+// | Dynamic    | Static     | AddStaticNeighbor (overwrite)               | LinkAddr |            | Removed, Added |
+// | Static     | Static     | Packet queued                               |          |            |         |
+// | Static     | Static     | Probe                                       |          |            |         |
+// | Static     | Static     | Confirmation                                |          |            |         |
+
+// TestEntryStaticToStaticWhenRepinned verifies that adding a static entry for
+// an address that already has a static entry updates its link address in
+// place, dispatching a single Changed event rather than tearing down and
+// recreating the entry.
+func TestEntryStaticToStaticWhenRepinned(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, nudDisp, _, _ := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.cache.mu.cache[entryTestAddr1] = e
+	e.cache.mu.Unlock()
+
+	e.cache.addStaticEntry(entryTestAddr1, entryTestLinkAddr1)
+
+	e.cache.mu.RLock()
+	pinned := e.cache.mu.cache[entryTestAddr1]
+	e.cache.mu.RUnlock()
+
+	e.cache.addStaticEntry(entryTestAddr1, entryTestLinkAddr2)
+
+	e.cache.mu.RLock()
+	repinned, ok := e.cache.mu.cache[entryTestAddr1]
+	e.cache.mu.RUnlock()
+	if !ok {
+		t.Fatalf("got no neighbor entry for %s, want one", entryTestAddr1)
+	}
+	if repinned != pinned {
+		t.Errorf("got a different *neighborEntry after re-pinning, want the same entry to be updated in place")
+	}
+
+	repinned.mu.Lock()
+	if repinned.mu.neigh.State != Static {
+		t.Errorf("got repinned.mu.neigh.State = %q, want = %q", repinned.mu.neigh.State, Static)
+	}
+	if repinned.mu.neigh.LinkAddr != entryTestLinkAddr2 {
+		t.Errorf("got repinned.mu.neigh.LinkAddr = %q, want = %q", repinned.mu.neigh.LinkAddr, entryTestLinkAddr2)
+	}
+	repinned.mu.Unlock()
+
+	nudDisp.mu.Lock()
+	defer nudDisp.mu.Unlock()
+	if len(nudDisp.events) == 0 {
+		t.Fatalf("got no dispatched events, want at least one Changed event")
+	}
+	last := nudDisp.events[len(nudDisp.events)-1]
+	want := testEntryEventInfo{
+		EventType: entryTestChanged,
+		NICID:     entryTestNICID,
+		Entry: NeighborEntry{
+			Addr:     entryTestAddr1,
+			LinkAddr: entryTestLinkAddr2,
+			State:    Static,
+		},
+	}
+	if diff := cmp.Diff(want, last, eventDiffOpts()...); diff != "" {
+		t.Errorf("final nud dispatcher event mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// TestEntryDynamicToStaticWhenReplaced verifies that adding a static entry
+// for an address that has an existing dynamic entry replaces it outright,
+// dispatching a Removed event for the old entry followed by an Added event
+// for the new Static one.
+func TestEntryDynamicToStaticWhenReplaced(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, nudDisp, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.setStateLocked(Stale)
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.mu.Unlock()
+
+	e.cache.addStaticEntry(entryTestAddr1, entryTestLinkAddr2)
+
+	e.cache.mu.RLock()
+	replaced, ok := e.cache.mu.cache[entryTestAddr1]
+	e.cache.mu.RUnlock()
+	if !ok {
+		t.Fatalf("got no neighbor entry for %s, want one", entryTestAddr1)
+	}
+	if replaced == e {
+		t.Errorf("got the same *neighborEntry after replacing a dynamic entry, want a new one")
+	}
+
+	replaced.mu.Lock()
+	if replaced.mu.neigh.State != Static {
+		t.Errorf("got replaced.mu.neigh.State = %q, want = %q", replaced.mu.neigh.State, Static)
+	}
+	if replaced.mu.neigh.LinkAddr != entryTestLinkAddr2 {
+		t.Errorf("got replaced.mu.neigh.LinkAddr = %q, want = %q", replaced.mu.neigh.LinkAddr, entryTestLinkAddr2)
+	}
+	replaced.mu.Unlock()
+
+	nudDisp.mu.Lock()
+	defer nudDisp.mu.Unlock()
+	if len(nudDisp.events) < 2 {
+		t.Fatalf("got %d dispatched events, want at least 2 (Removed, Added)", len(nudDisp.events))
+	}
+	wantTail := []testEntryEventInfo{
+		{
+			EventType: entryTestRemoved,
+			NICID:     entryTestNICID,
+			Entry: NeighborEntry{
+				Addr:     entryTestAddr1,
+				LinkAddr: entryTestLinkAddr1,
+				State:    Stale,
+			},
+		},
+		{
+			EventType: entryTestAdded,
+			NICID:     entryTestNICID,
+			Entry: NeighborEntry{
+				Addr:     entryTestAddr1,
+				LinkAddr: entryTestLinkAddr2,
+				State:    Static,
+			},
+		},
+	}
+	gotTail := nudDisp.events[len(nudDisp.events)-2:]
+	if diff := cmp.Diff(wantTail, gotTail, eventDiffOpts()...); diff != "" {
+		t.Errorf("final nud dispatcher events mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// TestEntryStaticNeverProbesOrFails verifies that a Static entry never sends
+// a reachability probe and never transitions to Failed, regardless of how
+// much time passes or how many packets are queued for transmission.
+func TestEntryStaticNeverProbesOrFails(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxUnicastProbes = 1
+	c.MaxMulticastProbes = 1
+	c.RetransmitTimer = minimumRetransmitTimer
+	e, _, linkRes, clock := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Static)
+	e.mu.Unlock()
+
+	for i := 0; i < 10; i++ {
+		e.mu.Lock()
+		e.handlePacketQueuedLocked(entryTestAddr2)
+		e.mu.Unlock()
+		clock.Advance(c.RetransmitTimer)
+	}
+
+	e.mu.Lock()
+	if e.mu.neigh.State != Static {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Static)
+	}
+	e.mu.Unlock()
+
+	linkRes.mu.Lock()
+	diff := cmp.Diff([]entryTestProbeInfo(nil), linkRes.probes)
+	linkRes.mu.Unlock()
+	if diff != "" {
+		t.Fatalf("link address resolver probes mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// TestEntryStaticToDynamicViaRemoveNeighbor verifies that removing a Static
+// entry returns its address to the normal NUD state machine: the next packet
+// queued for it starts over from Incomplete rather than reusing any Static
+// state.
+func TestEntryStaticToDynamicViaRemoveNeighbor(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.cache.mu.cache[entryTestAddr1] = e
+	e.cache.mu.Unlock()
+
+	e.cache.addStaticEntry(entryTestAddr1, entryTestLinkAddr1)
+	e.cache.removeEntry(entryTestAddr1)
+
+	e.cache.mu.RLock()
+	_, ok := e.cache.mu.cache[entryTestAddr1]
+	e.cache.mu.RUnlock()
+	if ok {
+		t.Fatalf("got a neighbor entry for %s after RemoveNeighbor, want none", entryTestAddr1)
+	}
+
+	_, resolved := e.cache.entry(entryTestAddr1, entryTestAddr2, nil, nil)
+	if resolved {
+		t.Fatalf("got entry() resolved = true for a freshly recreated entry, want false")
+	}
+
+	e.cache.mu.RLock()
+	recreated, ok := e.cache.mu.cache[entryTestAddr1]
+	e.cache.mu.RUnlock()
+	if !ok {
+		t.Fatalf("got no neighbor entry for %s after queuing a packet, want one", entryTestAddr1)
+	}
+
+	recreated.mu.Lock()
+	defer recreated.mu.Unlock()
+	if recreated.mu.neigh.State != Incomplete {
+		t.Errorf("got recreated.mu.neigh.State = %q, want = %q", recreated.mu.neigh.State, Incomplete)
+	}
+	if recreated.mu.neigh.LinkAddr != "" {
+		t.Errorf("got recreated.mu.neigh.LinkAddr = %q, want empty", recreated.mu.neigh.LinkAddr)
+	}
+}
+
+// TestNeighborCacheRemoveStaticEntrySkipsDynamic verifies that
+// removeStaticEntry leaves a dynamic entry untouched, only removing entries
+// that are actually Static.
+func TestNeighborCacheRemoveStaticEntrySkipsDynamic(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.cache.mu.cache[entryTestAddr1] = e
+	e.cache.mu.Unlock()
+
+	e.mu.Lock()
+	e.setStateLocked(Stale)
+	e.mu.Unlock()
+
+	if removed := e.cache.removeStaticEntry(entryTestAddr1); removed {
+		t.Errorf("got removeStaticEntry(%s) = true for a dynamic entry, want false", entryTestAddr1)
+	}
+
+	e.cache.mu.RLock()
+	_, ok := e.cache.mu.cache[entryTestAddr1]
+	e.cache.mu.RUnlock()
+	if !ok {
+		t.Fatalf("got no entry for %s after removeStaticEntry skipped it, want it to survive", entryTestAddr1)
+	}
+
+	e.cache.addStaticEntry(entryTestAddr1, entryTestLinkAddr1)
+	if removed := e.cache.removeStaticEntry(entryTestAddr1); !removed {
+		t.Errorf("got removeStaticEntry(%s) = false for a Static entry, want true", entryTestAddr1)
+	}
+
+	e.cache.mu.RLock()
+	defer e.cache.mu.RUnlock()
+	if _, ok := e.cache.mu.cache[entryTestAddr1]; ok {
+		t.Errorf("got an entry for %s after removeStaticEntry, want none", entryTestAddr1)
+	}
+}
+
+// TestEntryStaysStaticWhenPacketQueued verifies that a Static entry does not
+// transition states or send probes when a packet is queued for
+// transmission.
+func TestEntryStaysStaticWhenPacketQueued(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, linkRes, clock := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Static)
+	e.mu.Unlock()
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	if e.mu.neigh.State != Static {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Static)
+	}
+	e.mu.Unlock()
+
+	runImmediatelyScheduledJobs(clock)
+	linkRes.mu.Lock()
+	diff := cmp.Diff([]entryTestProbeInfo(nil), linkRes.probes)
+	linkRes.mu.Unlock()
+	if diff != "" {
+		t.Fatalf("link address resolver probes mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// TestEntryStaysStaticWhenProbed verifies that a Static entry answers
+// incoming probes without altering its cached link address or state.
+func TestEntryStaysStaticWhenProbed(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Static)
+	e.mu.Unlock()
+
+	e.mu.Lock()
+	e.handleProbeLocked(entryTestLinkAddr2)
+	if e.mu.neigh.State != Static {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Static)
+	}
+	if e.mu.neigh.LinkAddr != entryTestLinkAddr1 {
+		t.Errorf("got e.mu.neigh.LinkAddr = %q, want = %q", e.mu.neigh.LinkAddr, entryTestLinkAddr1)
+	}
+	e.mu.Unlock()
+}
+
+// TestEntryStaysStaticWhenConfirmed verifies that a Static entry ignores
+// reachability confirmations, including those with the Override flag set,
+// dispatching no event for them.
+func TestEntryStaysStaticWhenConfirmed(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, disp, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Static)
+	e.mu.Unlock()
+
+	disp.mu.Lock()
+	disp.events = nil
+	disp.mu.Unlock()
+
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr2, ReachabilityConfirmationFlags{
+		Solicited: true,
+		Override:  true,
+		IsRouter:  false,
+	})
+	if e.mu.neigh.State != Static {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Static)
+	}
+	if e.mu.neigh.LinkAddr != entryTestLinkAddr1 {
+		t.Errorf("got e.mu.neigh.LinkAddr = %q, want = %q", e.mu.neigh.LinkAddr, entryTestLinkAddr1)
+	}
+	e.mu.Unlock()
+
+	disp.mu.Lock()
+	defer disp.mu.Unlock()
+	if len(disp.events) != 0 {
+		t.Errorf("got %d dispatched events for a confirmation at a Static entry, want = 0: %+v", len(disp.events), disp.events)
+	}
+}
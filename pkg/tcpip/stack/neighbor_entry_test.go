@@ -138,8 +138,18 @@ func (e testEntryEventInfo) String() string {
 // testNUDDispatcher implements NUDDispatcher to validate the dispatching of
 // events upon certain NUD state machine events.
 type testNUDDispatcher struct {
-	mu     sync.Mutex
-	events []testEntryEventInfo
+	mu        sync.Mutex
+	events    []testEntryEventInfo
+	conflicts []testLinkAddressConflictInfo
+}
+
+// testLinkAddressConflictInfo records a call to
+// NUDDispatcher.OnNeighborLinkAddressConflict.
+type testLinkAddressConflictInfo struct {
+	NICID       tcpip.NICID
+	Addr        tcpip.Address
+	OldLinkAddr tcpip.LinkAddress
+	NewLinkAddr tcpip.LinkAddress
 }
 
 var _ NUDDispatcher = (*testNUDDispatcher)(nil)
@@ -174,9 +184,23 @@ func (d *testNUDDispatcher) OnNeighborRemoved(nicID tcpip.NICID, entry NeighborE
 	})
 }
 
+func (d *testNUDDispatcher) OnNeighborLinkAddressConflict(nicID tcpip.NICID, addr tcpip.Address, oldLinkAddr, newLinkAddr tcpip.LinkAddress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conflicts = append(d.conflicts, testLinkAddressConflictInfo{
+		NICID:       nicID,
+		Addr:        addr,
+		OldLinkAddr: oldLinkAddr,
+		NewLinkAddr: newLinkAddr,
+	})
+}
+
 type entryTestLinkResolver struct {
-	mu     sync.Mutex
-	probes []entryTestProbeInfo
+	mu sync.Mutex
+	// returnErr, if non-nil, is returned by LinkAddressRequest instead of
+	// recording and succeeding, to simulate a resolver-level send failure.
+	returnErr tcpip.Error
+	probes    []entryTestProbeInfo
 }
 
 var _ LinkAddressResolver = (*entryTestLinkResolver)(nil)
@@ -194,14 +218,16 @@ func (p entryTestProbeInfo) String() string {
 // LinkAddressRequest sends a request for the LinkAddress of addr. Broadcasts
 // to the local network if linkAddr is the zero value.
 func (r *entryTestLinkResolver) LinkAddressRequest(targetAddr, localAddr tcpip.Address, linkAddr tcpip.LinkAddress) tcpip.Error {
-	p := entryTestProbeInfo{
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.returnErr != nil {
+		return r.returnErr
+	}
+	r.probes = append(r.probes, entryTestProbeInfo{
 		RemoteAddress:     targetAddr,
 		RemoteLinkAddress: linkAddr,
 		LocalAddress:      localAddr,
-	}
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.probes = append(r.probes, p)
+	})
 	return nil
 }
 
@@ -363,6 +389,16 @@ func TestEntryUnknownToIncomplete(t *testing.T) {
 			t.Fatalf("nud dispatcher events mismatch (-want, +got):\n%s", diff)
 		}
 	}
+
+	if got, want := e.cache.stats.Transitions[Unknown][Incomplete].Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.Transitions[Unknown][Incomplete].Value() = %d, want = %d", got, want)
+	}
+	if got, want := e.cache.stats.EntriesByState[Incomplete].Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.EntriesByState[Incomplete].Value() = %d, want = %d", got, want)
+	}
+	if got, want := e.cache.stats.EntriesByState[Unknown].Value(), uint64(0); got != want {
+		t.Errorf("got e.cache.stats.EntriesByState[Unknown].Value() = %d, want = %d", got, want)
+	}
 }
 
 func TestEntryUnknownToStale(t *testing.T) {
@@ -562,6 +598,16 @@ func TestEntryIncompleteToReachable(t *testing.T) {
 		t.Errorf("nud dispatcher events mismatch (-want, +got):\n%s", diff)
 	}
 	nudDisp.mu.Unlock()
+
+	if got, want := e.cache.stats.Transitions[Incomplete][Reachable].Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.Transitions[Incomplete][Reachable].Value() = %d, want = %d", got, want)
+	}
+	if got, want := e.cache.stats.ResolutionSucceeded.Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.ResolutionSucceeded.Value() = %d, want = %d", got, want)
+	}
+	if got, want := e.cache.stats.EntriesByState[Reachable].Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.EntriesByState[Reachable].Value() = %d, want = %d", got, want)
+	}
 }
 
 func TestEntryIncompleteToReachableWithRouterFlag(t *testing.T) {
@@ -827,6 +873,16 @@ func TestEntryIncompleteToFailed(t *testing.T) {
 		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Failed)
 	}
 	e.mu.Unlock()
+
+	if got, want := e.cache.stats.Transitions[Incomplete][Failed].Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.Transitions[Incomplete][Failed].Value() = %d, want = %d", got, want)
+	}
+	if got, want := e.cache.stats.ResolutionFailed.Value(), uint64(1); got != want {
+		t.Errorf("got e.cache.stats.ResolutionFailed.Value() = %d, want = %d", got, want)
+	}
+	if got, want := e.cache.stats.EntriesByState[Failed].Value(), uint64(0); got != want {
+		t.Errorf("got e.cache.stats.EntriesByState[Failed].Value() = %d, want = %d (removed from the cache)", got, want)
+	}
 }
 
 type testLocker struct{}
@@ -2784,11 +2840,11 @@ func TestEntryStaysProbeWhenOverrideConfirmationWithSameAddress(t *testing.T) {
 }
 
 // TestEntryUnknownToStaleToProbeToReachable exercises the following scenario:
-//   1. Probe is received
-//   2. Entry is created in Stale
-//   3. Packet is queued on the entry
-//   4. Entry transitions to Delay then Probe
-//   5. Probe is sent
+//  1. Probe is received
+//  2. Entry is created in Stale
+//  3. Packet is queued on the entry
+//  4. Entry transitions to Delay then Probe
+//  5. Probe is sent
 func TestEntryUnknownToStaleToProbeToReachable(t *testing.T) {
 	c := DefaultNUDConfigurations()
 	// Eliminate random factors from ReachableTime computation so the transition
@@ -3355,9 +3411,27 @@ func TestEntryProbeToFailed(t *testing.T) {
 		e.mu.Unlock()
 	}
 
-	// Wait for the last probe to expire, causing a transition to Failed.
+	// Wait for the last unicast probe to expire. Per RFC 7048, this demotes
+	// the entry to Unreachable - where it remains in the cache with its
+	// previously resolved link address and continues unicast probing at a
+	// backoff interval - rather than removing it outright.
 	clock.Advance(c.RetransmitTimer)
 	e.mu.Lock()
+	if e.mu.neigh.State != Unreachable {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Unreachable)
+	}
+	e.mu.Unlock()
+
+	// Unreachable probing continues at an exponentially increasing interval
+	// - RetransmitTimer doubled each round, per the default
+	// UnreachableBackoffMultiplier - until MaxUnreachableProbes have also
+	// gone unanswered, at which point the entry is finally removed.
+	interval := c.RetransmitTimer
+	for i := uint32(0); i < c.MaxUnreachableProbes; i++ {
+		clock.Advance(interval)
+		interval *= time.Duration(c.UnreachableBackoffMultiplier)
+	}
+	e.mu.Lock()
 	if e.mu.neigh.State != Failed {
 		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Failed)
 	}
@@ -3400,13 +3474,22 @@ func TestEntryProbeToFailed(t *testing.T) {
 				State:    Probe,
 			},
 		},
+		{
+			EventType: entryTestChanged,
+			NICID:     entryTestNICID,
+			Entry: NeighborEntry{
+				Addr:     entryTestAddr1,
+				LinkAddr: entryTestLinkAddr1,
+				State:    Unreachable,
+			},
+		},
 		{
 			EventType: entryTestRemoved,
 			NICID:     entryTestNICID,
 			Entry: NeighborEntry{
 				Addr:     entryTestAddr1,
 				LinkAddr: entryTestLinkAddr1,
-				State:    Probe,
+				State:    Failed,
 			},
 		},
 	}
@@ -0,0 +1,129 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+)
+
+// TestEntryProbeToUnreachableToFailed verifies that a Probe entry transitions
+// to Unreachable, rather than Failed, once MaxUnicastProbes go unanswered,
+// that unicast probing continues at an exponentially increasing interval
+// while Unreachable, and that the entry only transitions to Failed once
+// MaxUnreachableProbes have also gone unanswered.
+func TestEntryProbeToUnreachableToFailed(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxUnicastProbes = 2
+	c.MaxUnreachableProbes = 2
+	c.UnreachableBackoffMultiplier = 2
+	c.MaxUnreachableInterval = 100 * c.RetransmitTimer
+	e, _, _, clock := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Probe)
+	e.mu.probesSent = 0
+	// Send the first unicast probe directly, mirroring what the Delay ->
+	// Probe transition does.
+	e.sendProbeLocked(entryTestAddr2)
+	e.mu.Unlock()
+	e.cache.mu.Unlock()
+
+	// The remaining MaxUnicastProbes-1 retransmits exhaust unicast probing
+	// and the MaxUnicastProbes'th expiry demotes the entry to Unreachable
+	// rather than Failed.
+	for i := uint32(0); i < c.MaxUnicastProbes; i++ {
+		clock.Advance(c.RetransmitTimer)
+	}
+
+	e.mu.Lock()
+	if e.mu.neigh.State != Unreachable {
+		t.Fatalf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Unreachable)
+	}
+	e.mu.Unlock()
+
+	// The first Unreachable probe was already sent on entry to the state;
+	// the backoff interval doubles before the second, and the entry
+	// transitions to Failed once MaxUnreachableProbes have gone unanswered.
+	clock.Advance(c.RetransmitTimer)
+	clock.Advance(2 * c.RetransmitTimer)
+
+	e.mu.Lock()
+	if e.mu.neigh.State != Failed {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Failed)
+	}
+	e.mu.Unlock()
+}
+
+// TestEntryUnreachableToReachableWhenSolicitedConfirmation verifies that an
+// Unreachable entry is resurrected to Reachable by a solicited reachability
+// confirmation, without the resolver needing to restart from Incomplete.
+func TestEntryUnreachableToReachableWhenSolicitedConfirmation(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Unreachable)
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: true,
+	})
+	if e.mu.neigh.State != Reachable {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Reachable)
+	}
+	e.mu.Unlock()
+}
+
+// TestEntryUnreachableToStaleWhenUnsolicitedOverrideConfirmation verifies
+// that an unsolicited, overriding reachability confirmation resurrects an
+// Unreachable entry to Stale, even when the advertised link address matches
+// what was already cached.
+func TestEntryUnreachableToStaleWhenUnsolicitedOverrideConfirmation(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Unreachable)
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: false,
+		Override:  true,
+	})
+	if e.mu.neigh.State != Stale {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Stale)
+	}
+	e.mu.Unlock()
+}
+
+// TestEntryUnreachableToDelayWhenPacketQueued verifies that queuing a packet
+// to an Unreachable entry transitions it to Delay, rather than resetting it
+// to Incomplete and discarding the link address already resolved.
+func TestEntryUnreachableToDelayWhenPacketQueued(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Unreachable)
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	if e.mu.neigh.State != Delay {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Delay)
+	}
+	if e.mu.neigh.LinkAddr != entryTestLinkAddr1 {
+		t.Errorf("got e.mu.neigh.LinkAddr = %q, want = %q", e.mu.neigh.LinkAddr, entryTestLinkAddr1)
+	}
+	e.mu.Unlock()
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "testing"
+
+// TestEntryProbeToReachableWhenUpperLevelConfirmation verifies that an
+// upper-layer reachability hint (e.g. a TCP endpoint accepting an in-window
+// ACK that advances SND.UNA), delivered in place of a solicited Neighbor
+// Advertisement, resurrects a Probe entry straight to Reachable without any
+// further reachability probe being sent for it.
+func TestEntryProbeToReachableWhenUpperLevelConfirmation(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	// Eliminate random factors from ReachableTime computation so the Reachable
+	// state entered via the hint lasts exactly BaseReachableTime.
+	c.MinRandomFactor = 1
+	c.MaxRandomFactor = 1
+
+	e, _, linkRes, clock := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.mu.Unlock()
+
+	runImmediatelyScheduledJobs(clock)
+	linkRes.mu.Lock()
+	linkRes.probes = nil
+	linkRes.mu.Unlock()
+
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: false,
+		Override:  false,
+		IsRouter:  false,
+	})
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.mu.Unlock()
+
+	clock.Advance(c.DelayFirstProbeTime)
+	e.mu.Lock()
+	if e.mu.neigh.State != Probe {
+		t.Fatalf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Probe)
+	}
+	linkRes.mu.Lock()
+	linkRes.probes = nil
+	linkRes.mu.Unlock()
+
+	e.handleUpperLevelConfirmationLocked()
+	if e.mu.neigh.State != Reachable {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Reachable)
+	}
+	if got, want := e.mu.neigh.LinkAddr, entryTestLinkAddr1; got != want {
+		t.Errorf("got e.mu.neigh.LinkAddr = %q, want = %q (unchanged by the upper-layer hint)", got, want)
+	}
+	e.mu.Unlock()
+
+	// No reachability probe should have been emitted as a result of the
+	// upper-layer hint; it was never solicited.
+	clock.Advance(c.RetransmitTimer)
+	linkRes.mu.Lock()
+	defer linkRes.mu.Unlock()
+	if len(linkRes.probes) != 0 {
+		t.Errorf("got %d probes sent after an upper-layer confirmation, want 0: %+v", len(linkRes.probes), linkRes.probes)
+	}
+}
+
+// TestNeighborCacheHandleUpperLevelConfirmation verifies that
+// neighborCache.HandleUpperLevelConfirmation looks up the entry for addr and
+// forwards the hint to it, and is a harmless no-op for an address with no
+// entry in the cache.
+func TestNeighborCacheHandleUpperLevelConfirmation(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Probe)
+	e.mu.Unlock()
+
+	// No entry exists for entryTestAddr2; this must not panic or create one.
+	e.cache.HandleUpperLevelConfirmation(entryTestAddr2)
+	e.cache.mu.RLock()
+	if _, ok := e.cache.mu.cache[entryTestAddr2]; ok {
+		t.Error("got an entry created for entryTestAddr2 by an upper-layer hint, want none")
+	}
+	e.cache.mu.RUnlock()
+
+	e.cache.HandleUpperLevelConfirmation(entryTestAddr1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.mu.neigh.State != Reachable {
+		t.Errorf("got e.mu.neigh.State = %q, want = %q", e.mu.neigh.State, Reachable)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+)
+
+// TestEntryReachableToStaleWhenGratuitousWithDifferentAddress verifies that a
+// gratuitous update for an existing entry with a differing link address
+// transitions it to Stale with the new address, mirroring
+// TestEntryProbeToStaleWhenConfirmationWithDifferentAddress.
+func TestEntryReachableToStaleWhenGratuitousWithDifferentAddress(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.mu.neigh.LinkAddr = entryTestLinkAddr1
+	e.setStateLocked(Reachable)
+	e.handleGratuitousLocked(entryTestLinkAddr2, false /* isRouter */)
+	if got, want := e.mu.neigh.State, Stale; got != want {
+		t.Errorf("got e.mu.neigh.State = %s, want = %s", got, want)
+	}
+	if got, want := e.mu.neigh.LinkAddr, entryTestLinkAddr2; got != want {
+		t.Errorf("got e.mu.neigh.LinkAddr = %q, want = %q", got, want)
+	}
+	e.mu.Unlock()
+}
+
+// TestNeighborCacheHandleGratuitousCreatesEntryWhenLearnFromGratuitousEnabled
+// verifies that a gratuitous update for an address with no existing entry
+// creates a new Stale entry, dispatching an Added event with
+// ReasonGratuitous, when NUDConfigurations.LearnFromGratuitous is enabled.
+func TestNeighborCacheHandleGratuitousCreatesEntryWhenLearnFromGratuitousEnabled(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.LearnFromGratuitous = true
+	e, _, _, _ := entryTestSetup(c)
+
+	ch := newNeighborEventChannel(defaultNeighborEventChannelCapacity)
+	e.cache.chanSubs.add(ch)
+
+	e.cache.handleGratuitous(entryTestAddr2, entryTestLinkAddr2, false /* isRouter */)
+
+	e.cache.mu.Lock()
+	created, ok := e.cache.mu.cache[entryTestAddr2]
+	e.cache.mu.Unlock()
+	if !ok {
+		t.Fatal("expected a new entry to be created for entryTestAddr2")
+	}
+
+	created.mu.Lock()
+	gotState := created.mu.neigh.State
+	gotLinkAddr := created.mu.neigh.LinkAddr
+	created.mu.Unlock()
+	if gotState != Stale {
+		t.Errorf("got created.mu.neigh.State = %s, want = %s", gotState, Stale)
+	}
+	if gotLinkAddr != entryTestLinkAddr2 {
+		t.Errorf("got created.mu.neigh.LinkAddr = %q, want = %q", gotLinkAddr, entryTestLinkAddr2)
+	}
+
+	select {
+	case evt := <-ch.ch:
+		if evt.Type != NeighborEventAdded {
+			t.Errorf("got event type = %s, want = %s", evt.Type, NeighborEventAdded)
+		}
+		if evt.Reason != ReasonGratuitous {
+			t.Errorf("got event reason = %s, want = %s", evt.Reason, ReasonGratuitous)
+		}
+	default:
+		t.Fatal("expected an Added event for the new entry, got none")
+	}
+}
+
+// TestNeighborCacheHandleGratuitousIgnoredByDefault verifies that a
+// gratuitous update for an address with no existing entry is ignored when
+// NUDConfigurations.LearnFromGratuitous is left at its default of false.
+func TestNeighborCacheHandleGratuitousIgnoredByDefault(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.cache.handleGratuitous(entryTestAddr2, entryTestLinkAddr2, false /* isRouter */)
+
+	e.cache.mu.Lock()
+	_, ok := e.cache.mu.cache[entryTestAddr2]
+	e.cache.mu.Unlock()
+	if ok {
+		t.Error("got an entry created for entryTestAddr2, want none since LearnFromGratuitous defaults to false")
+	}
+}
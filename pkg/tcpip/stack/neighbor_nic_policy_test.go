@@ -0,0 +1,143 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+	"time"
+)
+
+// TestEntryHonorsPerNICDelayFirstProbeTimeOverride verifies that a
+// handlePacketQueuedLocked-scheduled Delay->Probe transition honors a
+// per-NIC NUDConfigurations override applied directly to the cache's shared
+// NUDState, in place of the stack-wide default.
+func TestEntryHonorsPerNICDelayFirstProbeTimeOverride(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, clock := entryTestSetup(c)
+
+	override := c
+	override.DelayFirstProbeTime = time.Millisecond
+	e.cache.state.SetConfig(override)
+
+	e.mu.Lock()
+	e.setStateLocked(Stale)
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	if got, want := e.mu.neigh.State, Delay; got != want {
+		t.Fatalf("got e.mu.neigh.State = %s, want = %s", got, want)
+	}
+	e.mu.Unlock()
+
+	clock.Advance(override.DelayFirstProbeTime)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if got, want := e.mu.neigh.State, Probe; got != want {
+		t.Errorf("got e.mu.neigh.State = %s, want = %s after the overridden DelayFirstProbeTime elapsed", got, want)
+	}
+}
+
+// TestEntryHonorsPerNICBaseReachableTimeOverride verifies that the
+// Reachable->Stale timer scheduled by handleConfirmationLocked honors a
+// per-NIC NUDConfigurations override, once RecomputeReachableTime is called
+// to apply it.
+func TestEntryHonorsPerNICBaseReachableTimeOverride(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, clock := entryTestSetup(c)
+
+	override := c
+	override.BaseReachableTime = minimumBaseReachableTime
+	e.cache.state.SetConfig(override)
+	e.cache.state.RecomputeReachableTime()
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.mu.Unlock()
+	runImmediatelyScheduledJobs(clock)
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{Solicited: true})
+	if got, want := e.mu.neigh.State, Reachable; got != want {
+		t.Fatalf("got e.mu.neigh.State = %s, want = %s", got, want)
+	}
+	e.mu.Unlock()
+
+	// The overridden BaseReachableTime is tiny relative to the default, so
+	// any reasonable random factor applied on top of it has long since
+	// elapsed by the time the default would have even started to apply.
+	clock.Advance(10 * time.Millisecond)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if got, want := e.mu.neigh.State, Stale; got != want {
+		t.Errorf("got e.mu.neigh.State = %s, want = %s after the overridden BaseReachableTime elapsed", got, want)
+	}
+}
+
+// TestNeighborCacheForceStaleOnBringupSkipsIncomplete verifies that a
+// neighborCache with forceStaleOnBringup enabled creates new entries
+// directly in the Stale state, dispatching a single Added event with
+// ReasonForceStale, rather than starting them in Unknown/Incomplete.
+func TestNeighborCacheForceStaleOnBringupSkipsIncomplete(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	e.cache.mu.Lock()
+	e.cache.mu.forceStaleOnBringup = true
+	e.cache.mu.Unlock()
+
+	ch := newNeighborEventChannel(defaultNeighborEventChannelCapacity)
+	e.cache.chanSubs.add(ch)
+
+	e.cache.mu.Lock()
+	created := e.cache.getOrCreateEntryLocked(entryTestAddr2)
+	e.cache.mu.Unlock()
+
+	created.mu.Lock()
+	gotState := created.mu.neigh.State
+	created.mu.Unlock()
+	if want := Stale; gotState != want {
+		t.Errorf("got created.mu.neigh.State = %s, want = %s", gotState, want)
+	}
+
+	select {
+	case evt := <-ch.ch:
+		if evt.Type != NeighborEventAdded {
+			t.Errorf("got event type = %s, want = %s", evt.Type, NeighborEventAdded)
+		}
+		if evt.Reason != ReasonForceStale {
+			t.Errorf("got event reason = %s, want = %s", evt.Reason, ReasonForceStale)
+		}
+	default:
+		t.Fatal("expected an Added event for the force-Stale entry, got none")
+	}
+}
+
+// TestNeighborCacheDADDisabled verifies that Stack.SetNICDADDisabled's
+// underlying per-cache flag round-trips through DADDisabled.
+func TestNeighborCacheDADDisabled(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	if e.cache.DADDisabled() {
+		t.Fatal("got e.cache.DADDisabled() = true, want false before being set")
+	}
+
+	e.cache.mu.Lock()
+	e.cache.mu.disableDAD = true
+	e.cache.mu.Unlock()
+
+	if !e.cache.DADDisabled() {
+		t.Error("got e.cache.DADDisabled() = false, want true after being set")
+	}
+}
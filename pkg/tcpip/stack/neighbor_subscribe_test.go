@@ -0,0 +1,98 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "testing"
+
+// TestNeighborEventChannelReceivesReason verifies that a channel-based
+// subscriber registered directly on a neighborCache receives an event
+// carrying the NeighborEventReason that produced it.
+func TestNeighborEventChannelReceivesReason(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, clock := entryTestSetup(c)
+
+	ch := newNeighborEventChannel(defaultNeighborEventChannelCapacity)
+	e.cache.chanSubs.add(ch)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.mu.Unlock()
+	runImmediatelyScheduledJobs(clock)
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{Solicited: true})
+	e.mu.Unlock()
+
+	var got NeighborEvent
+	for i := 0; i < 2; i++ {
+		select {
+		case got = <-ch.ch:
+		default:
+			t.Fatalf("expected at least 2 events on the channel, got %d", i)
+		}
+	}
+	if got.Type != NeighborEventChanged {
+		t.Errorf("got last event type = %s, want = %s", got.Type, NeighborEventChanged)
+	}
+	if got.Reason != ReasonConfirmation {
+		t.Errorf("got last event reason = %s, want = %s", got.Reason, ReasonConfirmation)
+	}
+}
+
+// TestNeighborEventChannelOverflow verifies that once a subscriber's channel
+// buffer is full, further events are replaced by a best-effort
+// NeighborEventOverflow rather than blocking the dispatching goroutine.
+func TestNeighborEventChannelOverflow(t *testing.T) {
+	const capacity = 2
+	ch := newNeighborEventChannel(capacity)
+
+	for i := 0; i < capacity+1; i++ {
+		ch.notify(NeighborEvent{Type: NeighborEventChanged, Entry: NeighborEntry{Addr: entryTestAddr1}})
+	}
+
+	for i := 0; i < capacity; i++ {
+		if evt := <-ch.ch; evt.Type != NeighborEventChanged {
+			t.Errorf("got event %d type = %s, want = %s", i, evt.Type, NeighborEventChanged)
+		}
+	}
+	select {
+	case evt := <-ch.ch:
+		if evt.Type != NeighborEventOverflow {
+			t.Errorf("got trailing event type = %s, want = %s", evt.Type, NeighborEventOverflow)
+		}
+	default:
+		t.Fatal("expected a trailing NeighborEventOverflow, got no event")
+	}
+}
+
+// TestNeighborEventChannelSetRemove verifies that a channel removed from a
+// neighborEventChannelSet no longer receives events notified through it.
+func TestNeighborEventChannelSetRemove(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	ch := newNeighborEventChannel(defaultNeighborEventChannelCapacity)
+	e.cache.chanSubs.add(ch)
+	e.cache.chanSubs.remove(ch)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.mu.Unlock()
+
+	select {
+	case evt := <-ch.ch:
+		t.Errorf("got unexpected event %+v after remove, want none", evt)
+	default:
+	}
+}
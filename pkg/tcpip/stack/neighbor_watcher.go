@@ -0,0 +1,545 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// ErrNeighborWatcherNICNotFound is returned by Stack.NewNeighborWatcher when
+// asked to watch a NIC that does not exist.
+var ErrNeighborWatcherNICNotFound = errors.New("neighbor watcher: unknown NIC ID")
+
+// defaultNeighborWatcherMaxPending is the number of coalesced per-address
+// events a NeighborWatcher buffers before it starts dropping the oldest one
+// to make room for new ones.
+const defaultNeighborWatcherMaxPending = 256
+
+// NeighborEventType describes the kind of event delivered by a
+// NeighborWatcher's Watch call.
+type NeighborEventType int
+
+const (
+	// NeighborEventExisting reports an entry that was already present in a
+	// neighbor cache at the time a NeighborWatcher subscribed to it. A
+	// NeighborEventExisting is only delivered in the first batch returned by
+	// Watch; a later event for the same address that arrives before that
+	// batch is drained replaces it in place.
+	NeighborEventExisting NeighborEventType = iota
+
+	// NeighborEventIdle terminates the first batch returned by Watch, once
+	// every NeighborEventExisting has been delivered, mirroring the
+	// "existing followed by idle" convention of the external netstack's
+	// interfaces watcher.
+	NeighborEventIdle
+
+	// NeighborEventAdded reports that a new NeighborEntry was created.
+	NeighborEventAdded
+
+	// NeighborEventChanged reports that a NeighborEntry changed state or
+	// link address.
+	NeighborEventChanged
+
+	// NeighborEventRemoved reports that a NeighborEntry was removed.
+	NeighborEventRemoved
+
+	// NeighborEventOverflow reports that at least one coalesced per-address
+	// event was dropped from this NeighborWatcher's pending queue, because
+	// the watcher fell behind and its ring buffer of
+	// defaultNeighborWatcherMaxPending pending addresses was exceeded. It
+	// follows every coalesced event of the batch in which it appears; in the
+	// first batch, only the terminating NeighborEventIdle comes after it.
+	NeighborEventOverflow
+)
+
+// String implements fmt.Stringer.
+func (t NeighborEventType) String() string {
+	switch t {
+	case NeighborEventExisting:
+		return "Existing"
+	case NeighborEventIdle:
+		return "Idle"
+	case NeighborEventAdded:
+		return "Added"
+	case NeighborEventChanged:
+		return "Changed"
+	case NeighborEventRemoved:
+		return "Removed"
+	case NeighborEventOverflow:
+		return "Overflow"
+	default:
+		return fmt.Sprintf("unknown event type: %d", t)
+	}
+}
+
+// NeighborEventReason identifies what triggered a NeighborEventAdded,
+// NeighborEventChanged or NeighborEventRemoved. It is the zero value,
+// ReasonUnspecified, for every other NeighborEventType.
+type NeighborEventReason int
+
+const (
+	// ReasonUnspecified is the zero value of NeighborEventReason, carried by
+	// events that are not state-machine-triggered, e.g.
+	// NeighborEventExisting, NeighborEventIdle and NeighborEventOverflow.
+	ReasonUnspecified NeighborEventReason = iota
+
+	// ReasonPacketQueued means a packet was queued for transmission to an
+	// address with no usable route cached yet, as per RFC 4861 section
+	// 7.3.3.
+	ReasonPacketQueued
+
+	// ReasonProbe means a reachability probe was received from the
+	// neighbor, as per RFC 4861 section 7.2.3.
+	ReasonProbe
+
+	// ReasonConfirmation means a reachability confirmation was received from
+	// the neighbor, as per RFC 4861 section 7.2.5.
+	ReasonConfirmation
+
+	// ReasonUpperLayerConfirmation means an upper-layer protocol, such as
+	// TCP, reported a positive reachability confirmation, as per RFC 4861
+	// section 7.3.1.
+	ReasonUpperLayerConfirmation
+
+	// ReasonTimeout means a retransmit, delay-first-probe or reachable timer
+	// elapsed without a response.
+	ReasonTimeout
+
+	// ReasonStaticAdd means the entry was created, or had its link address
+	// updated, by an explicit Stack.AddStaticNeighbor call.
+	ReasonStaticAdd
+
+	// ReasonRemove means the entry was removed by an explicit
+	// Stack.RemoveNeighbor or Stack.ClearNeighbors call.
+	ReasonRemove
+
+	// ReasonForceStale means the entry was created directly in the Stale
+	// state because Stack.SetNICForceStaleOnBringup is enabled for its NIC,
+	// skipping the Incomplete probing phase.
+	ReasonForceStale
+
+	// ReasonGratuitous means the entry was created directly in the Stale
+	// state by a gratuitous ARP reply or an unsolicited NA with the
+	// Override flag set, for an address with no prior entry in the cache,
+	// because NUDConfigurations.LearnFromGratuitous is enabled. An update to
+	// an existing entry from the same kind of packet instead carries
+	// ReasonConfirmation, since it is handled as an unsolicited, overriding
+	// confirmation.
+	ReasonGratuitous
+
+	// ReasonGCEvicted means the entry was removed by neighborCache's LRU
+	// garbage collector to make room under NUDConfigurations.MaxNeighborEntries,
+	// rather than through Failed or an explicit removal call.
+	ReasonGCEvicted
+)
+
+// String implements fmt.Stringer.
+func (r NeighborEventReason) String() string {
+	switch r {
+	case ReasonUnspecified:
+		return "Unspecified"
+	case ReasonPacketQueued:
+		return "PacketQueued"
+	case ReasonProbe:
+		return "Probe"
+	case ReasonConfirmation:
+		return "Confirmation"
+	case ReasonUpperLayerConfirmation:
+		return "UpperLayerConfirmation"
+	case ReasonTimeout:
+		return "Timeout"
+	case ReasonStaticAdd:
+		return "StaticAdd"
+	case ReasonRemove:
+		return "Remove"
+	case ReasonForceStale:
+		return "ForceStale"
+	case ReasonGratuitous:
+		return "Gratuitous"
+	case ReasonGCEvicted:
+		return "GCEvicted"
+	default:
+		return fmt.Sprintf("unknown event reason: %d", r)
+	}
+}
+
+// NeighborEvent is a single event delivered by a NeighborWatcher's Watch
+// call or a Stack.SubscribeNeighborEvents channel. NeighborEventIdle and
+// NeighborEventOverflow carry no Entry or Reason.
+type NeighborEvent struct {
+	Type   NeighborEventType
+	NICID  tcpip.NICID
+	Entry  NeighborEntry
+	Reason NeighborEventReason
+}
+
+// NeighborWatcher streams coalesced NeighborEntry state changes for a single
+// NIC to a single consumer, following the "watcher with hanging get" pattern
+// used by the external netstack's interfaces watcher: Watch blocks until at
+// least one event is available, then returns every event accumulated since
+// the previous call in one batch.
+//
+// Multiple NeighborEntry events for the same address accumulated between two
+// Watch calls are coalesced to the latest one, so a slow watcher observes
+// only the terminal state of a neighbor that, say, transitioned through
+// Incomplete, Reachable and Stale between two of its Watch calls, rather than
+// every intermediate transition.
+type NeighborWatcher struct {
+	maxPending int
+
+	// caches holds every neighborCache this watcher was registered with, so
+	// that Close can unregister it from each in turn. It is populated once,
+	// before the NeighborWatcher is returned from NewNeighborWatcher, and
+	// never modified afterwards.
+	caches []*neighborCache
+
+	// signal is sent to, without blocking, whenever an event becomes
+	// available, and is read by a Watch call blocked waiting for one.
+	signal chan struct{}
+
+	mu struct {
+		sync.Mutex
+
+		// pending holds the latest coalesced event for every address with
+		// an event queued, and order is the order addresses were first
+		// queued in, used to implement drop-oldest once maxPending is
+		// exceeded.
+		pending map[tcpip.Address]NeighborEvent
+		order   []tcpip.Address
+
+		overflowed bool
+		firstWatch bool
+		closed     bool
+	}
+}
+
+// newNeighborWatcher returns a NeighborWatcher with an empty pending queue.
+func newNeighborWatcher(maxPending int) *NeighborWatcher {
+	w := &NeighborWatcher{
+		maxPending: maxPending,
+		signal:     make(chan struct{}, 1),
+	}
+	w.mu.pending = make(map[tcpip.Address]NeighborEvent)
+	w.mu.firstWatch = true
+	return w
+}
+
+// seedExisting queues a NeighborEventExisting event for entry, used to seed
+// a NeighborWatcher with a snapshot of a neighbor cache's state at the time
+// the watcher subscribed to it.
+func (w *NeighborWatcher) seedExisting(nicID tcpip.NICID, entry NeighborEntry) {
+	w.notify(NeighborEvent{Type: NeighborEventExisting, NICID: nicID, Entry: entry})
+}
+
+// notify queues evt, coalescing it with any event already pending for
+// evt.Entry.Addr, and wakes a Watch call blocked waiting for one.
+func (w *NeighborWatcher) notify(evt NeighborEvent) {
+	w.mu.Lock()
+	if _, ok := w.mu.pending[evt.Entry.Addr]; !ok {
+		if len(w.mu.order) >= w.maxPending {
+			oldest := w.mu.order[0]
+			w.mu.order = w.mu.order[1:]
+			delete(w.mu.pending, oldest)
+			w.mu.overflowed = true
+		}
+		w.mu.order = append(w.mu.order, evt.Entry.Addr)
+	}
+	w.mu.pending[evt.Entry.Addr] = evt
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// closeLocked marks the watcher closed and wakes any blocked Watch call.
+//
+// Precondition: w.mu MUST be locked for writing.
+func (w *NeighborWatcher) closeLocked() {
+	w.mu.closed = true
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// drainLocked removes and returns every event currently pending, appending a
+// NeighborEventOverflow if any event was dropped since the previous Watch
+// call, and a NeighborEventIdle if this is the first batch returned.
+//
+// Precondition: w.mu MUST be locked for writing.
+func (w *NeighborWatcher) drainLocked() []NeighborEvent {
+	events := make([]NeighborEvent, 0, len(w.mu.order)+2)
+	for _, addr := range w.mu.order {
+		events = append(events, w.mu.pending[addr])
+	}
+	w.mu.pending = make(map[tcpip.Address]NeighborEvent)
+	w.mu.order = nil
+
+	if w.mu.overflowed {
+		events = append(events, NeighborEvent{Type: NeighborEventOverflow})
+		w.mu.overflowed = false
+	}
+	if w.mu.firstWatch {
+		events = append(events, NeighborEvent{Type: NeighborEventIdle})
+		w.mu.firstWatch = false
+	}
+	return events
+}
+
+// Watch blocks until at least one NeighborEntry event is available, or ctx
+// is done, then returns every event accumulated since the previous call to
+// Watch in a single coalesced batch. The very first call to Watch returns a
+// NeighborEventExisting for every entry present at the time the watcher was
+// created, terminated by a NeighborEventIdle, even if no entry existed. An
+// entry's NeighborEventExisting is absent from that batch where a later event
+// for the same address was coalesced in its place, or where it was dropped on
+// overflow.
+func (w *NeighborWatcher) Watch(ctx context.Context) ([]NeighborEvent, error) {
+	for {
+		w.mu.Lock()
+		if len(w.mu.order) > 0 || w.mu.overflowed || w.mu.firstWatch {
+			events := w.drainLocked()
+			w.mu.Unlock()
+			return events, nil
+		}
+		closed := w.mu.closed
+		w.mu.Unlock()
+
+		if closed {
+			return nil, errNeighborWatcherClosed
+		}
+
+		select {
+		case <-w.signal:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// errNeighborWatcherClosed is returned by Watch once NeighborWatcher.Close
+// has been called.
+var errNeighborWatcherClosed = errors.New("neighbor watcher: closed")
+
+// neighborWatcherSet is the set of NeighborWatchers subscribed to a single
+// neighborCache's events.
+type neighborWatcherSet struct {
+	mu struct {
+		sync.Mutex
+		set map[*NeighborWatcher]struct{}
+	}
+}
+
+// init initializes the set for use. It must be called exactly once before
+// use.
+func (s *neighborWatcherSet) init() {
+	s.mu.set = make(map[*NeighborWatcher]struct{})
+}
+
+// add registers w to receive future events notified through this set.
+func (s *neighborWatcherSet) add(w *NeighborWatcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.set[w] = struct{}{}
+}
+
+// remove unregisters w, if present.
+func (s *neighborWatcherSet) remove(w *NeighborWatcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mu.set, w)
+}
+
+// notifyAll fans evt out to every watcher currently registered in the set.
+func (s *neighborWatcherSet) notifyAll(evt NeighborEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for w := range s.mu.set {
+		w.notify(evt)
+	}
+}
+
+// addWatcher registers w to receive future NUD events from this cache, and
+// seeds it with a NeighborEventExisting for every entry already present.
+func (n *neighborCache) addWatcher(w *NeighborWatcher) {
+	n.mu.RLock()
+	for _, entry := range n.mu.cache {
+		entry.mu.Lock()
+		w.seedExisting(n.nic.id, entry.mu.neigh)
+		entry.mu.Unlock()
+	}
+	n.mu.RUnlock()
+
+	n.watchers.add(w)
+}
+
+// removeWatcher unregisters w from this cache's watcher set.
+func (n *neighborCache) removeWatcher(w *NeighborWatcher) {
+	n.watchers.remove(w)
+}
+
+// Close unregisters the watcher from every NIC/network-protocol neighbor
+// cache it was subscribed to. After Close returns, any Watch call already
+// blocked, or made afterwards, first returns the events still pending, if
+// any, and then returns errNeighborWatcherClosed.
+func (w *NeighborWatcher) Close() {
+	w.mu.Lock()
+	w.closeLocked()
+	w.mu.Unlock()
+
+	for _, cache := range w.caches {
+		cache.removeWatcher(w)
+	}
+}
+
+// NewNeighborWatcher returns a NeighborWatcher streaming NeighborEntry events
+// for every network protocol's neighbor cache on the given NIC. It fans out
+// to any number of concurrently active watchers: each one gets its own
+// bounded, coalesced view of events, independent of every other watcher and
+// of any NUDDispatcher configured on the stack. Call NeighborWatcher.Close
+// once the caller no longer wants to watch.
+func (s *Stack) NewNeighborWatcher(nicID tcpip.NICID) (*NeighborWatcher, error) {
+	s.mu.RLock()
+	nic, ok := s.nics[nicID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNeighborWatcherNICNotFound
+	}
+
+	w := newNeighborWatcher(defaultNeighborWatcherMaxPending)
+	for _, linkRes := range nic.linkAddrResolvers {
+		linkRes.neigh.addWatcher(w)
+		w.caches = append(w.caches, &linkRes.neigh)
+	}
+	return w, nil
+}
+
+// defaultNeighborEventChannelCapacity is the number of events the channel
+// returned by Stack.SubscribeNeighborEvents buffers. The channel holds one
+// more slot, reserved for a trailing NeighborEventOverflow.
+const defaultNeighborEventChannelCapacity = 64
+
+// neighborEventChannel is a single Stack.SubscribeNeighborEvents subscriber.
+// Unlike a NeighborWatcher, which a consumer polls with a blocking Watch
+// call and which coalesces repeated events for the same address, a
+// neighborEventChannel pushes every event as it happens onto a buffered
+// Go channel, in line with the "reason code" subscriber this request asked
+// for.
+type neighborEventChannel struct {
+	ch chan NeighborEvent
+}
+
+func newNeighborEventChannel(capacity int) *neighborEventChannel {
+	return &neighborEventChannel{ch: make(chan NeighborEvent, capacity+1)}
+}
+
+// notify pushes evt onto the channel without blocking. Once capacity events
+// are buffered, evt is dropped and a NeighborEventOverflow is pushed into the
+// reserved last slot instead, on a best-effort basis, so a slow subscriber
+// learns it missed something instead of silently falling behind.
+func (c *neighborEventChannel) notify(evt NeighborEvent) {
+	if len(c.ch) < cap(c.ch)-1 {
+		select {
+		case c.ch <- evt:
+			return
+		default:
+		}
+	}
+	select {
+	case c.ch <- NeighborEvent{Type: NeighborEventOverflow}:
+	default:
+	}
+}
+
+// neighborEventChannelSet is the set of neighborEventChannels subscribed to
+// a single neighborCache's events.
+type neighborEventChannelSet struct {
+	mu struct {
+		sync.Mutex
+		set map[*neighborEventChannel]struct{}
+	}
+}
+
+// init initializes the set for use. It must be called exactly once before
+// use.
+func (s *neighborEventChannelSet) init() {
+	s.mu.set = make(map[*neighborEventChannel]struct{})
+}
+
+// add registers c to receive future events notified through this set.
+func (s *neighborEventChannelSet) add(c *neighborEventChannel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.set[c] = struct{}{}
+}
+
+// remove unregisters c, if present.
+func (s *neighborEventChannelSet) remove(c *neighborEventChannel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mu.set, c)
+}
+
+// notifyAll fans evt out to every channel subscriber currently registered
+// in the set.
+func (s *neighborEventChannelSet) notifyAll(evt NeighborEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.mu.set {
+		c.notify(evt)
+	}
+}
+
+// SubscribeNeighborEvents registers a channel-based subscriber for
+// NeighborEvents - each carrying a NeighborEventReason identifying the NUD
+// state-machine transition that produced it - across every network
+// protocol's neighbor cache on the given NIC. Unlike NewNeighborWatcher,
+// events are pushed to the returned channel as they happen rather than
+// batched behind a blocking Watch call; a subscriber that falls behind the
+// channel's buffer sees a trailing NeighborEventOverflow rather than
+// blocking event dispatch.
+//
+// The returned channel is closed, and the subscription torn down,
+// once ctx is done.
+func (s *Stack) SubscribeNeighborEvents(ctx context.Context, nicID tcpip.NICID) (<-chan NeighborEvent, error) {
+	s.mu.RLock()
+	nic, ok := s.nics[nicID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNeighborWatcherNICNotFound
+	}
+
+	c := newNeighborEventChannel(defaultNeighborEventChannelCapacity)
+	for _, linkRes := range nic.linkAddrResolvers {
+		linkRes.neigh.chanSubs.add(c)
+	}
+
+	go func() {
+		<-ctx.Done()
+		for _, linkRes := range nic.linkAddrResolvers {
+			linkRes.neigh.chanSubs.remove(c)
+		}
+		close(c.ch)
+	}()
+
+	return c.ch, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// watchEventDiffOpts ignores NeighborEntry.UpdatedAtNanos, which has no
+// deterministic value to compare against.
+func watchEventDiffOpts() []cmp.Option {
+	return []cmp.Option{cmpopts.IgnoreFields(NeighborEntry{}, "UpdatedAtNanos")}
+}
+
+// TestNeighborWatcherLateSubscriberSeesSnapshotThenDeltas verifies that a
+// watcher that subscribes after a neighbor entry has already transitioned
+// several times sees only a single NeighborEventExisting reflecting the
+// entry's current state, terminated by NeighborEventIdle, and that
+// subsequent transitions are delivered as individual deltas from then on.
+func TestNeighborWatcherLateSubscriberSeesSnapshotThenDeltas(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, _ := entryTestSetup(c)
+
+	// Drive the entry through a few transitions before any watcher
+	// subscribes.
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{
+		Solicited: true,
+	})
+	snapshot := e.mu.neigh
+	e.mu.Unlock()
+
+	w := newNeighborWatcher(defaultNeighborWatcherMaxPending)
+	e.cache.addWatcher(w)
+
+	ctx := context.Background()
+	events, err := w.Watch(ctx)
+	if err != nil {
+		t.Fatalf("w.Watch(ctx) failed: %s", err)
+	}
+	wantEvents := []NeighborEvent{
+		{Type: NeighborEventExisting, NICID: entryTestNICID, Entry: snapshot},
+		{Type: NeighborEventIdle},
+	}
+	if diff := cmp.Diff(wantEvents, events, watchEventDiffOpts()...); diff != "" {
+		t.Fatalf("first Watch events mismatch (-want, +got):\n%s", diff)
+	}
+
+	// A later transition should be observed as a single delta, not bundled
+	// with anything from before the watcher subscribed.
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr2, ReachabilityConfirmationFlags{
+		Solicited: false,
+		Override:  true,
+	})
+	afterChange := e.mu.neigh
+	e.mu.Unlock()
+
+	events, err = w.Watch(ctx)
+	if err != nil {
+		t.Fatalf("w.Watch(ctx) failed: %s", err)
+	}
+	wantEvents = []NeighborEvent{
+		{Type: NeighborEventChanged, NICID: entryTestNICID, Entry: afterChange, Reason: ReasonConfirmation},
+	}
+	if diff := cmp.Diff(wantEvents, events, watchEventDiffOpts()...); diff != "" {
+		t.Fatalf("second Watch events mismatch (-want, +got):\n%s", diff)
+	}
+
+	w.Close()
+}
+
+// TestNeighborWatcherCoalescesRepeatedChanges verifies that multiple events
+// for the same address accumulated between two Watch calls are collapsed to
+// the latest one.
+func TestNeighborWatcherCoalescesRepeatedChanges(t *testing.T) {
+	w := newNeighborWatcher(defaultNeighborWatcherMaxPending)
+
+	// Drain the (empty) Existing/Idle batch first.
+	if _, err := w.Watch(context.Background()); err != nil {
+		t.Fatalf("w.Watch(ctx) failed: %s", err)
+	}
+
+	entry1 := NeighborEntry{Addr: entryTestAddr1, State: Reachable}
+	entry2 := NeighborEntry{Addr: entryTestAddr1, State: Stale}
+	w.notify(NeighborEvent{Type: NeighborEventChanged, NICID: entryTestNICID, Entry: entry1})
+	w.notify(NeighborEvent{Type: NeighborEventChanged, NICID: entryTestNICID, Entry: entry2})
+
+	events, err := w.Watch(context.Background())
+	if err != nil {
+		t.Fatalf("w.Watch(ctx) failed: %s", err)
+	}
+	want := []NeighborEvent{{Type: NeighborEventChanged, NICID: entryTestNICID, Entry: entry2}}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("Watch events mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// TestNeighborWatcherOverflow verifies that once more distinct addresses are
+// pending than the watcher's capacity, the oldest is dropped and a
+// NeighborEventOverflow terminates the next batch.
+func TestNeighborWatcherOverflow(t *testing.T) {
+	const maxPending = 2
+	w := newNeighborWatcher(maxPending)
+
+	if _, err := w.Watch(context.Background()); err != nil {
+		t.Fatalf("w.Watch(ctx) failed: %s", err)
+	}
+
+	addrs := []tcpip.Address{
+		entryTestAddr1,
+		entryTestAddr2,
+		tcpip.Address("\x00\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03"),
+	}
+	for _, addr := range addrs {
+		w.notify(NeighborEvent{Type: NeighborEventChanged, NICID: entryTestNICID, Entry: NeighborEntry{Addr: addr}})
+	}
+
+	events, err := w.Watch(context.Background())
+	if err != nil {
+		t.Fatalf("w.Watch(ctx) failed: %s", err)
+	}
+	if len(events) != maxPending+1 {
+		t.Fatalf("got len(events) = %d, want = %d", len(events), maxPending+1)
+	}
+	last := events[len(events)-1]
+	if last.Type != NeighborEventOverflow {
+		t.Errorf("got last event type = %q, want = %q", last.Type, NeighborEventOverflow)
+	}
+}
+
+// TestNeighborWatcherCloseUnblocksWatch verifies that Close causes any
+// subsequent Watch call to return an error instead of blocking forever.
+func TestNeighborWatcherCloseUnblocksWatch(t *testing.T) {
+	w := newNeighborWatcher(defaultNeighborWatcherMaxPending)
+	if _, err := w.Watch(context.Background()); err != nil {
+		t.Fatalf("w.Watch(ctx) failed: %s", err)
+	}
+
+	w.Close()
+
+	if _, err := w.Watch(context.Background()); err == nil {
+		t.Error("got w.Watch(ctx) = nil error, want non-nil after Close")
+	}
+}
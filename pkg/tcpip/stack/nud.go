@@ -0,0 +1,386 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// Default values for NUDConfigurations as defined by RFC 4861 section 10.
+const (
+	defaultBaseReachableTime           = 30 * time.Second
+	minimumBaseReachableTime           = time.Millisecond
+	defaultMinRandomFactor             = 0.5
+	defaultMaxRandomFactor             = 1.5
+	defaultRetransmitTimer             = time.Second
+	minimumRetransmitTimer             = time.Millisecond
+	defaultDelayFirstProbeTime         = 5 * time.Second
+	defaultMaxMulticastProbes          = 3
+	defaultMaxUnicastProbes            = 3
+	defaultMaxAnycastDelayTime         = time.Second
+	defaultMaxReachbilityConfirmations = 3
+
+	// defaultMaxPendingPacketsPerNeighbor is the default number of packets
+	// that are queued for outgoing transmission while a neighbor is being
+	// resolved.
+	defaultMaxPendingPacketsPerNeighbor = 8
+
+	// Default values for the RFC 7048 Unreachable state.
+	defaultMaxUnreachableProbes         = 3
+	defaultUnreachableBackoffMultiplier = 2
+	defaultMaxUnreachableInterval       = 60 * time.Second
+
+	// defaultMaxNeighborEntries is the default cap on the number of entries a
+	// single neighbor cache may hold before its LRU garbage collector starts
+	// evicting eligible entries to make room.
+	defaultMaxNeighborEntries = 512
+)
+
+// NUDConfigurations is the NUD configurations for the netstack. This is used
+// by the neighbor cache to operate the NUD state machine on each neighbor in
+// the cache.
+//
+// +stateify savable
+type NUDConfigurations struct {
+	// BaseReachableTime is the base duration for computing the random
+	// reachable time.
+	//
+	// Reachable time is the duration for which a neighbor is considered
+	// reachable after a positive reachability confirmation is received. It is
+	// a function of a uniformly distributed random value between the minimum
+	// and maximum random factors, multiplied by the base reachable time. Using
+	// a random component eliminates the possibility that Neighbor Unreachability
+	// Detection messages will synchronize with each other.
+	//
+	// Must be greater than 0.
+	BaseReachableTime time.Duration
+
+	// LearnBaseReachableTime enables learning BaseReachableTime during
+	// operation of the NUD state machine, if supported by the link.
+	//
+	// TODO(gvisor.dev/issue/2240): Implement this NUD configuration option.
+	LearnBaseReachableTime bool
+
+	// MinRandomFactor is the minimum value of the random factor used for
+	// computing reachable time.
+	//
+	// Must be greater than 0.
+	MinRandomFactor float32
+
+	// MaxRandomFactor is the maximum value of the random factor used for
+	// computing reachable time.
+	//
+	// Must be greater than or equal to MinRandomFactor.
+	MaxRandomFactor float32
+
+	// RetransmitTimer is the duration between retransmission of reachability
+	// probes in the Probe state.
+	//
+	// Must be greater than 0.
+	RetransmitTimer time.Duration
+
+	// LearnRetransmitTimer enables learning RetransmitTimer during operation
+	// of the NUD state machine, if supported by the link.
+	//
+	// TODO(gvisor.dev/issue/2241): Implement this NUD configuration option.
+	LearnRetransmitTimer bool
+
+	// DelayFirstProbeTime is the duration to wait for a non-Neighbor-Discovery
+	// related protocol to reconfirm reachability after entering the Delay
+	// state. After this time, the neighbor is moved from Delay to Probe,
+	// triggering the first reachability probe.
+	//
+	// Must be greater than 0.
+	DelayFirstProbeTime time.Duration
+
+	// MaxMulticastProbes is the number of reachability probes to send before
+	// concluding negative reachability confirmation and deleting neighbor
+	// entries that have no link-layer address resolved, or were never
+	// discovered (e.g. no solicited-confirmation has been received).
+	//
+	// Must be greater than 0.
+	MaxMulticastProbes uint32
+
+	// MaxUnicastProbes is the number of reachability probes to send before
+	// concluding retransmission failure and moving the Neighbor Cache entry to
+	// the Unreachable state.
+	//
+	// Must be greater than 0.
+	MaxUnicastProbes uint32
+
+	// MaxAnycastDelayTime is the time in which the stack SHOULD delay sending
+	// a response for a random time between 0 and this value if the target
+	// address is an anycast address.
+	//
+	// TODO(gvisor.dev/issue/2242): Use this option when sending solicited
+	// neighbor advertisements to anycast addresses.
+	MaxAnycastDelayTime time.Duration
+
+	// MaxReachbilityConfirmations is the number of unsolicited reachability
+	// confirmations to send after an address is resolved.
+	//
+	// TODO(gvisor.dev/issue/2242): Use this option to send unsolicited
+	// advertisements when an anycast address is resolved.
+	MaxReachbilityConfirmations uint32
+
+	// MaxPendingPacketsPerNeighbor is the maximum number of packets that can
+	// be queued for outgoing transmission for a neighbor in the Incomplete,
+	// Probe, Delay or Unreachable states. Once the bound is reached, the oldest queued
+	// packet is dropped to make room for the new one.
+	//
+	// Must be greater than 0.
+	MaxPendingPacketsPerNeighbor uint32
+
+	// MaxUnreachableProbes is the number of unicast reachability probes to
+	// send, at exponentially increasing intervals, to a neighbor in the
+	// Unreachable state before concluding retransmission failure and
+	// deleting the neighbor cache entry, as per RFC 7048.
+	//
+	// Must be greater than 0.
+	MaxUnreachableProbes uint32
+
+	// UnreachableBackoffMultiplier is the factor by which the interval
+	// between unicast reachability probes is multiplied after each
+	// unanswered probe sent to a neighbor in the Unreachable state.
+	//
+	// Must be greater than or equal to 1.
+	UnreachableBackoffMultiplier uint32
+
+	// MaxUnreachableInterval is the upper bound on the interval between
+	// unicast reachability probes sent to a neighbor in the Unreachable
+	// state, regardless of how many probes have already gone unanswered.
+	//
+	// Must be greater than 0.
+	MaxUnreachableInterval time.Duration
+
+	// RetransmitJitter is the fraction, between 0 and 1 exclusive, by which
+	// the exponentially increasing interval between unicast reachability
+	// probes sent to a neighbor in the Unreachable state is randomized, to
+	// avoid multiple neighbors' probes synchronizing with each other as per
+	// RFC 7048's "NUD optimization". Each interval is independently
+	// multiplied by a value drawn uniformly from
+	// [1-RetransmitJitter, 1+RetransmitJitter] before being capped at
+	// MaxUnreachableInterval. The zero value disables jitter entirely.
+	//
+	// Must be greater than or equal to 0 and less than 1.
+	RetransmitJitter float32
+
+	// LearnFromGratuitous controls whether a gratuitous ARP reply or an
+	// unsolicited NA with the Override flag set may create a new Stale
+	// entry for an address with no prior entry in the cache. It defaults to
+	// false, since blindly learning neighbors from unsolicited
+	// advertisements is a cache-poisoning vector; an existing entry is
+	// always updated regardless of this setting.
+	LearnFromGratuitous bool
+
+	// MaxNeighborEntries is the maximum number of entries a neighbor cache
+	// may hold before its LRU garbage collector starts evicting entries -
+	// the least-recently-used entry in Stale, Unreachable or Failed, never
+	// Incomplete, Delay, Probe or Static - to make room for new ones. This
+	// bounds memory use under an adversarial or scanning workload that
+	// causes many distinct addresses to be looked up.
+	//
+	// Must be greater than 0.
+	MaxNeighborEntries uint32
+}
+
+// DefaultNUDConfigurations returns a NUDConfigurations populated with
+// default values defined by RFC 4861 section 10.
+func DefaultNUDConfigurations() NUDConfigurations {
+	c := NUDConfigurations{
+		BaseReachableTime:            defaultBaseReachableTime,
+		MinRandomFactor:              defaultMinRandomFactor,
+		MaxRandomFactor:              defaultMaxRandomFactor,
+		RetransmitTimer:              defaultRetransmitTimer,
+		DelayFirstProbeTime:          defaultDelayFirstProbeTime,
+		MaxMulticastProbes:           defaultMaxMulticastProbes,
+		MaxUnicastProbes:             defaultMaxUnicastProbes,
+		MaxAnycastDelayTime:          defaultMaxAnycastDelayTime,
+		MaxReachbilityConfirmations:  defaultMaxReachbilityConfirmations,
+		MaxPendingPacketsPerNeighbor: defaultMaxPendingPacketsPerNeighbor,
+		MaxUnreachableProbes:         defaultMaxUnreachableProbes,
+		UnreachableBackoffMultiplier: defaultUnreachableBackoffMultiplier,
+		MaxUnreachableInterval:       defaultMaxUnreachableInterval,
+		MaxNeighborEntries:           defaultMaxNeighborEntries,
+	}
+	c.resetInvalidFields()
+	return c
+}
+
+// resetInvalidFields modifies an invalid NUDConfigurations with valid
+// values. If invalid values are detected, the corresponding default value is
+// used instead.
+func (c *NUDConfigurations) resetInvalidFields() {
+	if c.BaseReachableTime < minimumBaseReachableTime {
+		c.BaseReachableTime = defaultBaseReachableTime
+	}
+	if c.MinRandomFactor <= 0 {
+		c.MinRandomFactor = defaultMinRandomFactor
+	}
+	if c.MaxRandomFactor < c.MinRandomFactor {
+		c.MaxRandomFactor = defaultMaxRandomFactor
+	}
+	if c.RetransmitTimer < minimumRetransmitTimer {
+		c.RetransmitTimer = defaultRetransmitTimer
+	}
+	if c.DelayFirstProbeTime <= 0 {
+		c.DelayFirstProbeTime = defaultDelayFirstProbeTime
+	}
+	if c.MaxMulticastProbes == 0 {
+		c.MaxMulticastProbes = defaultMaxMulticastProbes
+	}
+	if c.MaxUnicastProbes == 0 {
+		c.MaxUnicastProbes = defaultMaxUnicastProbes
+	}
+	if c.MaxPendingPacketsPerNeighbor == 0 {
+		c.MaxPendingPacketsPerNeighbor = defaultMaxPendingPacketsPerNeighbor
+	}
+	if c.MaxUnreachableProbes == 0 {
+		c.MaxUnreachableProbes = defaultMaxUnreachableProbes
+	}
+	if c.UnreachableBackoffMultiplier < 1 {
+		c.UnreachableBackoffMultiplier = defaultUnreachableBackoffMultiplier
+	}
+	if c.MaxUnreachableInterval <= 0 {
+		c.MaxUnreachableInterval = defaultMaxUnreachableInterval
+	}
+	if c.RetransmitJitter < 0 || c.RetransmitJitter >= 1 {
+		c.RetransmitJitter = 0
+	}
+	if c.MaxNeighborEntries == 0 {
+		c.MaxNeighborEntries = defaultMaxNeighborEntries
+	}
+}
+
+// NUDState stores states needed for NUD. It exists outside of neighborEntry
+// so that it may outlive a neighborEntry's removal from the cache, and so
+// that multiple neighborEntry's created for the same neighbor over time may
+// share the same base reachable time and random generator.
+//
+// +stateify savable
+type NUDState struct {
+	mu sync.RWMutex
+
+	config NUDConfigurations
+
+	// reachableTime is the current duration to wait before a neighbor in the
+	// Reachable state is considered stale. It is computed from config's
+	// BaseReachableTime, but is randomized on construction and on every
+	// recomputation.
+	reachableTime time.Duration
+
+	rng *rand.Rand
+
+	// prevRandomFactor is the random factor used in the most recent
+	// computation of reachableTime.
+	prevRandomFactor float32
+}
+
+// NewNUDState returns new NUDState using configuration c and random source
+// rng.
+func NewNUDState(c NUDConfigurations, rng *rand.Rand) *NUDState {
+	s := &NUDState{
+		config: c,
+		rng:    rng,
+	}
+	s.reachableTime = s.calculateRandomReachableTime()
+	return s
+}
+
+// Config returns a copy of the NUD configurations.
+func (s *NUDState) Config() NUDConfigurations {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// SetConfig replaces the existing NUD configurations with c.
+func (s *NUDState) SetConfig(c NUDConfigurations) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = c
+}
+
+// ReachableTime returns the current duration to wait until a neighbor in the
+// Reachable state is considered Stale. It returns the stored value without
+// recomputing it; RecomputeReachableTime does that.
+func (s *NUDState) ReachableTime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reachableTime
+}
+
+// RecomputeReachableTime forces a recomputation of ReachableTime using a new
+// random factor, as required when BaseReachableTime changes, or periodically
+// per RFC 4861 section 6.3.4 to avoid synchronization of messages from
+// different hosts.
+func (s *NUDState) RecomputeReachableTime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reachableTime = s.calculateRandomReachableTime()
+	return s.reachableTime
+}
+
+// backoffJitterMultiplier returns 1 if jitter is 0, otherwise a value drawn
+// uniformly from [1-jitter, 1+jitter], for randomizing the interval between
+// unicast reachability probes sent to a neighbor in the Unreachable state.
+func (s *NUDState) backoffJitterMultiplier(jitter float32) float32 {
+	if jitter == 0 {
+		return 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return 1 - jitter + s.rng.Float32()*2*jitter
+}
+
+// calculateRandomReachableTime returns a random reachable time computed as
+// defined by RFC 4861 section 6.3.2.
+//
+// Precondition: s.mu MUST be locked for writing.
+func (s *NUDState) calculateRandomReachableTime() time.Duration {
+	random := s.rng.Float32()*(s.config.MaxRandomFactor-s.config.MinRandomFactor) + s.config.MinRandomFactor
+	s.prevRandomFactor = random
+	return time.Duration(random * float32(s.config.BaseReachableTime))
+}
+
+// SetNICNUDConfigurations overrides the NUD configurations used by the given
+// NIC and network protocol, leaving every other NIC's configuration - and
+// the stack-wide default used by NICs added afterwards - untouched. Invalid
+// fields in c are reset to their default values, exactly as
+// DefaultNUDConfigurations does. This lets operators shorten timers such as
+// DelayFirstProbeTime or MaxUnicastProbes on interfaces where the attached
+// L2 is known to be point-to-point, without affecting the rest of the stack.
+func (s *Stack) SetNICNUDConfigurations(id tcpip.NICID, protocol tcpip.NetworkProtocolNumber, c NUDConfigurations) tcpip.Error {
+	s.mu.RLock()
+	nic, ok := s.nics[id]
+	s.mu.RUnlock()
+	if !ok {
+		return &tcpip.ErrUnknownNICID{}
+	}
+
+	linkRes, ok := nic.linkAddrResolvers[protocol]
+	if !ok {
+		return &tcpip.ErrNotSupported{}
+	}
+
+	c.resetInvalidFields()
+	linkRes.neigh.state.SetConfig(c)
+	linkRes.neigh.state.RecomputeReachableTime()
+	return nil
+}
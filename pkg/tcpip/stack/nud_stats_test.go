@@ -0,0 +1,104 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "testing"
+
+// TestNUDStatsEntriesByStateDecrementsOnRemoval verifies that the
+// EntriesByState gauge returns to zero once every entry that contributed to
+// it has been removed from the cache, regardless of which state it was
+// removed from.
+func TestNUDStatsEntriesByStateDecrementsOnRemoval(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	c.MaxMulticastProbes = 1
+	e, _, _, clock := entryTestSetup(c)
+
+	if got, want := e.cache.stats.EntriesByState[Unknown].Value(), uint64(1); got != want {
+		t.Fatalf("got e.cache.stats.EntriesByState[Unknown].Value() = %d, want = %d", got, want)
+	}
+
+	// Drive the entry to Reachable, then remove it via RemoveNeighbor.
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.mu.Unlock()
+	runImmediatelyScheduledJobs(clock)
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{Solicited: true})
+	e.mu.Unlock()
+
+	if got, want := e.cache.stats.EntriesByState[Reachable].Value(), uint64(1); got != want {
+		t.Fatalf("got e.cache.stats.EntriesByState[Reachable].Value() = %d, want = %d", got, want)
+	}
+
+	e.cache.removeEntry(entryTestAddr1)
+
+	for state := Unknown; state <= Failed; state++ {
+		if got, want := e.cache.stats.EntriesByState[state].Value(), uint64(0); got != want {
+			t.Errorf("got e.cache.stats.EntriesByState[%s].Value() = %d, want = %d after removal", state, got, want)
+		}
+	}
+
+	// A second entry that fails resolution outright (Incomplete -> Failed)
+	// must also leave every bucket at zero once it's gone.
+	e2 := newNeighborEntry(e.cache, entryTestAddr2, e.cache.state)
+	e.cache.mu.Lock()
+	e.cache.mu.cache[entryTestAddr2] = e2
+	e.cache.mu.Unlock()
+
+	e2.mu.Lock()
+	e2.handlePacketQueuedLocked(entryTestAddr1)
+	e2.mu.Unlock()
+	clock.Advance(c.RetransmitTimer * 2)
+
+	for state := Unknown; state <= Failed; state++ {
+		if got, want := e.cache.stats.EntriesByState[state].Value(), uint64(0); got != want {
+			t.Errorf("got e.cache.stats.EntriesByState[%s].Value() = %d, want = %d after the second entry failed and was evicted", state, got, want)
+		}
+	}
+}
+
+// TestNUDStatsAggregatesAcrossNetworkProtocols verifies that
+// Stack.NeighborStats sums the per-protocol counters returned by
+// Stack.NICNeighborStats rather than reporting just one of them.
+func TestNUDStatsAggregatesAcrossNetworkProtocols(t *testing.T) {
+	c := DefaultNUDConfigurations()
+	e, _, _, clock := entryTestSetup(c)
+
+	e.mu.Lock()
+	e.handlePacketQueuedLocked(entryTestAddr2)
+	e.mu.Unlock()
+	runImmediatelyScheduledJobs(clock)
+	e.mu.Lock()
+	e.handleConfirmationLocked(entryTestLinkAddr1, ReachabilityConfirmationFlags{Solicited: true})
+	e.mu.Unlock()
+
+	stack := e.cache.nic.stack
+	byProto, err := stack.NICNeighborStats(e.cache.nic.id)
+	if err != nil {
+		t.Fatalf("stack.NICNeighborStats(%d) failed: %s", e.cache.nic.id, err)
+	}
+	var wantResolved uint64
+	for _, stats := range byProto {
+		wantResolved += stats.ResolutionSucceeded.Value()
+	}
+
+	agg, err := stack.NeighborStats(e.cache.nic.id)
+	if err != nil {
+		t.Fatalf("stack.NeighborStats(%d) failed: %s", e.cache.nic.id, err)
+	}
+	if got := agg.ResolutionSucceeded.Value(); got != wantResolved {
+		t.Errorf("got agg.ResolutionSucceeded.Value() = %d, want = %d", got, wantResolved)
+	}
+}
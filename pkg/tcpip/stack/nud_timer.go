@@ -0,0 +1,199 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// timerHandle identifies a single logical timer (a retransmit, a
+// delay-first-probe, or a reachable-timeout) owned by a neighborEntry and
+// scheduled on its cache's shared neighborTimers heap.
+//
+// fire invokes fn holding no lock at all, so fn is responsible for acquiring
+// whatever locks its own mutation of entry or cache state requires - in
+// particular, a fn that may remove its entry from the cache must acquire the
+// owning neighborCache's mu before the entry's own mu, same as every other
+// cache-mutating path.
+type timerHandle struct {
+	seq      uint64
+	deadline time.Time
+	fn       func()
+
+	// index is this handle's position in the owning heap, or -1 if it is
+	// not currently scheduled.
+	index int
+}
+
+// timerMinHeap implements container/heap.Interface, ordering timerHandles by
+// deadline, breaking ties by sequence number so that same-tick timers fire
+// in the order they were scheduled.
+type timerMinHeap []*timerHandle
+
+func (h timerMinHeap) Len() int { return len(h) }
+
+func (h timerMinHeap) Less(i, j int) bool {
+	if h[i].deadline.Equal(h[j].deadline) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h timerMinHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerMinHeap) Push(x any) {
+	t := x.(*timerHandle)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerMinHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// neighborTimers batches every pending per-entry NUD timer belonging to a
+// neighborCache into a single min-heap keyed by deadline, driven by one
+// shared clock timer rather than one Go timer per neighborEntry. At the
+// tens-of-thousands-of-neighbors scale common on Kubernetes nodes, one timer
+// goroutine per entry is a significant source of allocations and wakeups;
+// this coalesces same-tick expirations into a single batch popped from the
+// heap under one lock acquisition.
+type neighborTimers struct {
+	clock tcpip.Clock
+
+	mu struct {
+		sync.Mutex
+
+		heap timerMinHeap
+		seq  uint64
+
+		// timer is armed for the current earliest deadline in heap, or nil
+		// if heap is empty.
+		timer tcpip.Timer
+	}
+}
+
+// init associates the timer wheel with the clock used to schedule and fire
+// its timers. It must be called exactly once before use.
+func (t *neighborTimers) init(clock tcpip.Clock) {
+	t.clock = clock
+	t.mu.heap = nil
+}
+
+// schedule arranges for fn to run once, after d elapses, and returns a
+// handle that can later be passed to cancel or reschedule.
+func (t *neighborTimers) schedule(d time.Duration, fn func()) *timerHandle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.mu.seq++
+	h := &timerHandle{
+		seq:      t.mu.seq,
+		deadline: t.clock.Now().Add(d),
+		fn:       fn,
+		index:    -1,
+	}
+	heap.Push(&t.mu.heap, h)
+	t.rearmLocked()
+	return h
+}
+
+// cancel removes h from the wheel, if it is still pending. It is a no-op if
+// h has already fired or was already canceled.
+func (t *neighborTimers) cancel(h *timerHandle) {
+	if h == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h.index < 0 {
+		return
+	}
+	heap.Remove(&t.mu.heap, h.index)
+	t.rearmLocked()
+}
+
+// reschedule cancels h, if pending, and reinserts it with a new deadline of
+// now + d, preserving its callback.
+func (t *neighborTimers) reschedule(h *timerHandle, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if h.index >= 0 {
+		heap.Remove(&t.mu.heap, h.index)
+	}
+	h.deadline = t.clock.Now().Add(d)
+	heap.Push(&t.mu.heap, h)
+	t.rearmLocked()
+}
+
+// rearmLocked (re)arms the single underlying clock timer to fire when the
+// earliest deadline remaining in the heap is due, stopping any timer
+// previously armed for a deadline that is no longer the earliest.
+//
+// Precondition: t.mu MUST be locked.
+func (t *neighborTimers) rearmLocked() {
+	if t.mu.timer != nil {
+		t.mu.timer.Stop()
+		t.mu.timer = nil
+	}
+	if len(t.mu.heap) == 0 {
+		return
+	}
+	d := t.mu.heap[0].deadline.Sub(t.clock.Now())
+	if d < 0 {
+		d = 0
+	}
+	t.mu.timer = t.clock.AfterFunc(d, t.fire)
+}
+
+// fire runs the callback for every timer whose deadline has elapsed as of
+// now, then rearms the underlying clock timer for whatever deadline is
+// earliest afterwards. All due timers are popped from the heap - and so
+// run - in a single batch per wakeup, rather than one wakeup per entry.
+//
+// Callbacks run with t.mu released and with no neighborCache or
+// neighborEntry lock held, since fn runs on the clock's own goroutine under
+// a production tcpip.Clock rather than synchronously with whatever caller
+// last touched the entry. See timerHandle's doc comment for the locking
+// contract this places on fn.
+func (t *neighborTimers) fire() {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	var due []*timerHandle
+	for len(t.mu.heap) > 0 && !t.mu.heap[0].deadline.After(now) {
+		due = append(due, heap.Pop(&t.mu.heap).(*timerHandle))
+	}
+	t.rearmLocked()
+	t.mu.Unlock()
+
+	for _, h := range due {
+		h.fn()
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+)
+
+// BenchmarkNeighborTimersSchedule measures the cost of scheduling a timer in
+// the coalesced wheel at a scale representative of a node with tens of
+// thousands of neighbor entries, each holding at most one outstanding timer.
+func BenchmarkNeighborTimersSchedule(b *testing.B) {
+	clock := faketime.NewManualClock()
+	var timers neighborTimers
+	timers.init(clock)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timers.schedule(time.Second, func() {})
+	}
+}
+
+// BenchmarkNeighborTimersScheduleCancel measures the cost of scheduling and
+// then canceling a timer before it fires, the common case for a neighbor
+// entry that is confirmed reachable before its retransmit timer expires.
+func BenchmarkNeighborTimersScheduleCancel(b *testing.B) {
+	clock := faketime.NewManualClock()
+	var timers neighborTimers
+	timers.init(clock)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h := timers.schedule(time.Second, func() {})
+		timers.cancel(h)
+	}
+}
+
+// BenchmarkNeighborTimersFireAtScale measures the cost of firing a single
+// batch of 10,000 simultaneously-expiring timers, the scenario this wheel is
+// designed to optimize relative to one Go timer (and one timer goroutine) per
+// neighborEntry.
+func BenchmarkNeighborTimersFireAtScale(b *testing.B) {
+	const n = 10000
+
+	clock := faketime.NewManualClock()
+	var timers neighborTimers
+	timers.init(clock)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		fired := 0
+		for j := 0; j < n; j++ {
+			timers.schedule(time.Second, func() { fired++ })
+		}
+		b.StartTimer()
+
+		clock.Advance(time.Second)
+
+		if fired != n {
+			b.Fatalf("got %d timers fired, want %d", fired, n)
+		}
+	}
+}